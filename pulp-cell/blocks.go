@@ -2,8 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	pulpgin "github.com/BananaLabs-OSS/Fiber/pulp/gin"
 	"github.com/BananaLabs-OSS/Fiber/pulp/gin/middleware"
@@ -16,9 +23,26 @@ type FriendshipRemover interface {
 	RemoveFriendship(ctx context.Context, accountA, accountB uuid.UUID) error
 }
 
+// activeBlockExpr matches blocks still in force at the bound time.
+// Every read of blocks filters on it, so an expired block stops
+// counting the moment it lapses, before the sweep removes it.
+const activeBlockExpr = "(expires_at IS NULL OR expires_at > ?)"
+
+// maxBlockDuration caps how far out a temporary block may end.
+const maxBlockDuration = 365 * 24 * time.Hour
+
+// maxBlockNoteLen caps the free-text note on a block, in characters.
+const maxBlockNoteLen = 500
+
+// blockSweepInterval throttles the lazy purge of expired blocks.
+const blockSweepInterval = 10 * time.Minute
+
 type BlocksHandler struct {
 	db      *bun.DB
 	friends FriendshipRemover
+
+	sweepMu   sync.Mutex
+	lastSweep time.Time
 }
 
 func NewBlocksHandler(db *bun.DB, friends FriendshipRemover) *BlocksHandler {
@@ -49,7 +73,46 @@ func (h *BlocksHandler) BlockUser(c *pulpgin.Context) {
 		return
 	}
 
+	now := time.Now().UTC()
+	expiresAt, err := blockExpiry(req, now)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	req.Note = strings.TrimSpace(req.Note)
+	if req.Reason != "" && !req.Reason.Valid() {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_reason",
+			Message: "reason must be spam, harassment, cheating, inappropriate or other",
+		})
+		return
+	}
+	if utf8.RuneCountInString(req.Note) > maxBlockNoteLen || strings.ContainsFunc(req.Note, isNoteControl) {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_note",
+			Message: fmt.Sprintf("note must be at most %d characters of plain text", maxBlockNoteLen),
+		})
+		return
+	}
+	if req.Note != "" && req.Reason == "" {
+		req.Reason = ReasonOther
+	}
+
 	ctx := c.Ctx()
+	h.sweepExpired(ctx)
+
+	// A lapsed block for the pair would trip the unique index.
+	if _, err := h.db.NewDelete().
+		Model((*Block)(nil)).
+		Where("blocker_id = ? AND blocked_id = ? AND expires_at <= ?", blockerID, req.AccountID, now).
+		Exec(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
 
 	exists, err := h.db.NewSelect().
 		Model((*Block)(nil)).
@@ -71,17 +134,67 @@ func (h *BlocksHandler) BlockUser(c *pulpgin.Context) {
 		ID:        uuid.New(),
 		BlockerID: blockerID,
 		BlockedID: req.AccountID,
-		CreatedAt: time.Now().UTC(),
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	report := BlockReportEntry{
+		ID:        uuid.New(),
+		BlockerID: blockerID,
+		BlockedID: req.AccountID,
+		Reason:    req.Reason,
+		Note:      req.Note,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
 	}
 
-	if _, err := h.db.NewInsert().Model(&block).Exec(ctx); err != nil {
+	err = h.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewInsert().Model(&block).Exec(ctx); err != nil {
+			return err
+		}
+		_, err := tx.NewInsert().Model(&report).Exec(ctx)
+		return err
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "creation_failed"})
 		return
 	}
 
 	_ = h.friends.RemoveFriendship(ctx, blockerID, req.AccountID)
 
-	c.JSON(http.StatusCreated, pulpgin.H{"status": "blocked"})
+	c.JSON(http.StatusCreated, pulpgin.H{"status": "blocked", "expires_at": expiresAt})
+}
+
+// isNoteControl rejects control characters in a block note, except
+// line breaks.
+func isNoteControl(r rune) bool {
+	return unicode.IsControl(r) && r != '\n'
+}
+
+// blockExpiry turns the optional duration or expires_at of a block
+// request into its end time; nil means the block is permanent.
+func blockExpiry(req BlockInput, now time.Time) (*time.Time, error) {
+	var expiresAt time.Time
+	switch {
+	case req.Duration != "" && req.ExpiresAt != nil:
+		return nil, errors.New("set duration or expires_at, not both")
+	case req.Duration != "":
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			return nil, errors.New("duration must be a positive duration such as \"24h\"")
+		}
+		expiresAt = now.Add(d)
+	case req.ExpiresAt != nil:
+		expiresAt = req.ExpiresAt.UTC()
+		if !expiresAt.After(now) {
+			return nil, errors.New("expires_at must be in the future")
+		}
+	default:
+		return nil, nil
+	}
+	if expiresAt.Sub(now) > maxBlockDuration {
+		return nil, fmt.Errorf("temporary blocks may last at most %s", maxBlockDuration)
+	}
+	return &expiresAt, nil
 }
 
 func (h *BlocksHandler) UnblockUser(c *pulpgin.Context) {
@@ -104,6 +217,7 @@ func (h *BlocksHandler) UnblockUser(c *pulpgin.Context) {
 	result, err := h.db.NewDelete().
 		Model((*Block)(nil)).
 		Where("blocker_id = ? AND blocked_id = ?", blockerID, blockedID).
+		Where(activeBlockExpr, time.Now().UTC()).
 		Exec(ctx)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
@@ -128,25 +242,75 @@ func (h *BlocksHandler) ListBlocked(c *pulpgin.Context) {
 		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_token", Message: "Malformed account_id in token"})
 		return
 	}
+	page, err := parsePageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_request", Message: err.Error()})
+		return
+	}
 	ctx := c.Ctx()
+	h.sweepExpired(ctx)
 
-	var blockRows []Block
-	err = h.db.NewSelect().
-		Model(&blockRows).
+	now := time.Now().UTC()
+	q := h.db.NewSelect().
+		Model((*Block)(nil)).
 		Where("blocker_id = ?", blockerID).
-		Scan(ctx)
-	if err != nil {
+		Where(activeBlockExpr, now)
+
+	var blockRows []Block
+	if err := page.apply(q, "created_at", "id").Scan(ctx, &blockRows); err != nil {
 		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
 		return
 	}
+	blockRows, next := nextPage(blockRows, page, blockCursor)
 
 	blocked := make([]BlockedUser, 0, len(blockRows))
 	for _, b := range blockRows {
-		blocked = append(blocked, BlockedUser{
+		entry := BlockedUser{
 			AccountID: b.BlockedID,
 			Since:     b.CreatedAt,
-		})
+			ExpiresAt: b.ExpiresAt,
+		}
+		if b.ExpiresAt != nil {
+			remaining := int64(b.ExpiresAt.Sub(now).Seconds())
+			entry.RemainingSeconds = &remaining
+		}
+		blocked = append(blocked, entry)
+	}
+
+	c.JSON(http.StatusOK, pulpgin.H{"blocks": blocked, "next_cursor": next})
+}
+
+// sweepExpired deletes lapsed temporary blocks, at most once per
+// blockSweepInterval. Reads already ignore them; this only keeps the
+// table from growing.
+func (h *BlocksHandler) sweepExpired(ctx context.Context) {
+	h.sweepMu.Lock()
+	if time.Since(h.lastSweep) < blockSweepInterval {
+		h.sweepMu.Unlock()
+		return
 	}
+	h.lastSweep = time.Now()
+	h.sweepMu.Unlock()
+
+	if _, err := h.db.NewDelete().
+		Model((*Block)(nil)).
+		Where("expires_at <= ?", time.Now().UTC()).
+		Exec(ctx); err != nil {
+		log.Printf("blocks: failed to sweep expired blocks: %v", err)
+	}
+}
+
+// blockedEitherWay reports whether either account has an active block
+// on the other.
+func blockedEitherWay(ctx context.Context, db bun.IDB, accountA, accountB uuid.UUID) (bool, error) {
+	return db.NewSelect().
+		Model((*Block)(nil)).
+		Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)",
+			accountA, accountB, accountB, accountA).
+		Where(activeBlockExpr, time.Now().UTC()).
+		Exists(ctx)
+}
 
-	c.JSON(http.StatusOK, pulpgin.H{"blocks": blocked})
+func blockCursor(b Block) pageCursor {
+	return pageCursor{At: b.CreatedAt, ID: b.ID}
 }
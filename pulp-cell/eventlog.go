@@ -0,0 +1,119 @@
+package main
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// replayBufferSize is how many recent events the hub keeps per
+	// account for ?resume=.
+	replayBufferSize = 100
+	// replayRetention is how long an offline account's events are kept
+	// for it to resume from.
+	replayRetention = 10 * time.Minute
+	// replaySweepInterval throttles dropping expired logs.
+	replaySweepInterval = time.Minute
+)
+
+// eventLog numbers the events pushed to one account and keeps the most
+// recent of them so a reconnecting client can catch up. It outlives the
+// account's sessions by replayRetention.
+type eventLog struct {
+	// last is the seq of the newest event. A new log starts from the
+	// current time in microseconds, so seqs handed out by a log that
+	// has since expired, or by an earlier run of the cell, are always
+	// below a fresh log's range and read as needing a resync.
+	last   uint64
+	events []PresenceMessage
+	// offlineSince is when the account's last session closed; zero
+	// while it is connected.
+	offlineSince time.Time
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{last: uint64(time.Now().UnixMicro())}
+}
+
+// append numbers msg, keeps it for replay and returns it with its seq.
+func (l *eventLog) append(msg PresenceMessage) PresenceMessage {
+	l.last++
+	msg.Seq = l.last
+	if len(l.events) == replayBufferSize {
+		copy(l.events, l.events[1:])
+		l.events = l.events[:replayBufferSize-1]
+	}
+	l.events = append(l.events, msg)
+	return msg
+}
+
+// since returns the events after seq, or false when some of them are no
+// longer buffered or seq did not come from this log.
+func (l *eventLog) since(seq uint64) ([]PresenceMessage, bool) {
+	if seq > l.last {
+		return nil, false
+	}
+	if seq == l.last {
+		return nil, true
+	}
+	if len(l.events) == 0 || l.events[0].Seq > seq+1 {
+		return nil, false
+	}
+	i := 0
+	for i < len(l.events) && l.events[i].Seq <= seq {
+		i++
+	}
+	return append([]PresenceMessage(nil), l.events[i:]...), true
+}
+
+// Resume replays to connID the events accountID missed after seq. It
+// reports false, after sending resync_required, when they can't all be
+// replayed; the client should then rebuild from a fresh snapshot.
+func (h *Hub) Resume(accountID uuid.UUID, connID uint64, seq uint64) bool {
+	h.mu.Lock()
+	var missed []PresenceMessage
+	ok := false
+	if events, tracked := h.logs[accountID]; tracked {
+		missed, ok = events.since(seq)
+	}
+	// Events recorded while the account was offline weren't checked
+	// against its mutes.
+	if acct, online := h.accounts[accountID]; online && ok {
+		kept := missed[:0]
+		for _, msg := range missed {
+			if mutedEvents[msg.Type] {
+				if subject, err := uuid.Parse(msg.AccountID); err == nil && acct.muted[subject] {
+					continue
+				}
+			}
+			kept = append(kept, msg)
+		}
+		missed = kept
+	}
+	h.mu.Unlock()
+
+	if !ok {
+		h.reply(accountID, connID, ResyncMessage{Type: "resync_required"})
+		return false
+	}
+	for _, msg := range missed {
+		h.reply(accountID, connID, msg)
+	}
+	return true
+}
+
+// sweepLogsLocked drops the logs of accounts offline for longer than
+// replayRetention, at most once per replaySweepInterval. Caller must
+// hold h.mu.
+func (h *Hub) sweepLogsLocked(now time.Time) {
+	if now.Sub(h.lastLogSweep) < replaySweepInterval {
+		return
+	}
+	h.lastLogSweep = now
+	for id, events := range h.logs {
+		if !events.offlineSince.IsZero() && now.Sub(events.offlineSince) > replayRetention {
+			delete(h.logs, id)
+		}
+	}
+}
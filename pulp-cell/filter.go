@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// errMessageRejected is returned by filters for text moderation turned
+// down. Handlers map it to a 422.
+var errMessageRejected = errors.New("message rejected by content filter")
+
+// MessageFilter vets user-written text before it is stored or pushed
+// to another player. It is the hook moderation plugs into. Rejections
+// wrap errMessageRejected; any other error is treated as the filter
+// failing, not as a verdict on the text.
+type MessageFilter interface {
+	Check(ctx context.Context, accountID uuid.UUID, text string) error
+}
+
+// wordListFilter rejects text containing any of a fixed list of
+// case-insensitive substrings. It is the filter the cell runs with
+// unless something smarter is wired in.
+type wordListFilter struct {
+	words []string
+}
+
+func newWordListFilter(words []string) *wordListFilter {
+	f := &wordListFilter{}
+	for _, w := range words {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			f.words = append(f.words, w)
+		}
+	}
+	return f
+}
+
+func (f *wordListFilter) Check(_ context.Context, _ uuid.UUID, text string) error {
+	lower := strings.ToLower(text)
+	for _, w := range f.words {
+		if strings.Contains(lower, w) {
+			return errMessageRejected
+		}
+	}
+	return nil
+}
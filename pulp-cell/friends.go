@@ -3,8 +3,15 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	pulpgin "github.com/BananaLabs-OSS/Fiber/pulp/gin"
 	"github.com/BananaLabs-OSS/Fiber/pulp/gin/middleware"
@@ -12,12 +19,51 @@ import (
 	"github.com/uptrace/bun"
 )
 
+// PresenceHub is the part of Hub the friends handler uses: pushing
+// social-graph changes to connected clients and asking who is online.
+type PresenceHub interface {
+	SendToAccount(accountID uuid.UUID, msg PresenceMessage)
+	FriendshipFormed(accountA, accountB uuid.UUID)
+	FriendshipEnded(accountA, accountB uuid.UUID)
+	VisibleOnline(viewer uuid.UUID, accountIDs []uuid.UUID) ([]uuid.UUID, error)
+	BulkPresence(accountIDs []uuid.UUID) map[uuid.UUID]PresenceInfo
+	OnlineFriends(viewer uuid.UUID, friendIDs []uuid.UUID) ([]FriendPresence, error)
+}
+
+// maxRequestMessageLen caps the optional note on a friend request, in
+// characters.
+const maxRequestMessageLen = 200
+
+// requestSweepInterval throttles the lazy purge of expired requests;
+// reads filter expired rows on their own, so the sweep only has to
+// keep the table from growing.
+const requestSweepInterval = 10 * time.Minute
+
 type FriendsHandler struct {
-	db *bun.DB
+	db       *bun.DB
+	lastSeen *LastSeenStore
+	settings *SettingsStore
+	hub      PresenceHub
+
+	// requestTTL is how long a pending request lives; zero disables
+	// expiry.
+	requestTTL time.Duration
+	// filter vets request messages before they are stored.
+	filter MessageFilter
+
+	sweepMu   sync.Mutex
+	lastSweep time.Time
 }
 
-func NewFriendsHandler(db *bun.DB) *FriendsHandler {
-	return &FriendsHandler{db: db}
+func NewFriendsHandler(db *bun.DB, lastSeen *LastSeenStore, settings *SettingsStore, requestTTL time.Duration, filter MessageFilter) *FriendsHandler {
+	return &FriendsHandler{db: db, lastSeen: lastSeen, settings: settings, requestTTL: requestTTL, filter: filter}
+}
+
+// SetHub wires the hub in after construction; the hub itself needs
+// the FriendsHandler to list friends, so neither can be built with the
+// other.
+func (h *FriendsHandler) SetHub(hub PresenceHub) {
+	h.hub = hub
 }
 
 func (h *FriendsHandler) SendRequest(c *pulpgin.Context) {
@@ -46,11 +92,29 @@ func (h *FriendsHandler) SendRequest(c *pulpgin.Context) {
 
 	ctx := c.Ctx()
 
-	blocked, err := h.db.NewSelect().
-		Model((*Block)(nil)).
-		Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)",
-			accountID, req.FriendID, req.FriendID, accountID).
-		Exists(ctx)
+	req.Message = strings.TrimSpace(req.Message)
+	if utf8.RuneCountInString(req.Message) > maxRequestMessageLen || strings.ContainsFunc(req.Message, unicode.IsControl) {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_message",
+			Message: fmt.Sprintf("message must be at most %d characters of plain text", maxRequestMessageLen),
+		})
+		return
+	}
+	if req.Message != "" {
+		if err := h.filter.Check(ctx, accountID, req.Message); errors.Is(err, errMessageRejected) {
+			c.JSON(http.StatusUnprocessableEntity, middleware.ErrorResponse{
+				Error:   "message_rejected",
+				Message: err.Error(),
+			})
+			return
+		} else if err != nil {
+			log.Printf("friends: message filter failed: %v", err)
+			c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "filter_error"})
+			return
+		}
+	}
+
+	blocked, err := blockedEitherWay(ctx, h.db, accountID, req.FriendID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
 		return
@@ -63,13 +127,46 @@ func (h *FriendsHandler) SendRequest(c *pulpgin.Context) {
 		return
 	}
 
+	h.sweepExpired(ctx)
+
+	// An expired request in either direction must neither block this
+	// one nor be auto-accepted by it.
+	if cutoff, ok := h.expiryCutoff(); ok {
+		if _, err := h.db.NewDelete().
+			Model((*Friendship)(nil)).
+			Where("((requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)) AND status = ? AND created_at < ?",
+				accountID, req.FriendID, req.FriendID, accountID, StatusPending, cutoff).
+			Exec(ctx); err != nil {
+			c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+			return
+		}
+	}
+
 	var existing Friendship
 	err = h.db.NewSelect().
 		Model(&existing).
-		Where("(requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)",
-			accountID, req.FriendID, req.FriendID, accountID).
+		Where("((requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)) AND status IN (?)",
+			accountID, req.FriendID, req.FriendID, accountID, bun.In([]FriendshipStatus{StatusPending, StatusAccepted})).
 		Scan(ctx)
 	if err == nil {
+		// Both players clicked "add": the other side's pending
+		// request counts as the answer to this one. The caller's
+		// message has nowhere to go and is dropped.
+		if existing.Status == StatusPending && existing.RequesterID == req.FriendID {
+			if err := h.accept(ctx, &existing, accountID); err != nil {
+				c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "update_failed"})
+				return
+			}
+			// The caller's other sessions didn't see this coming.
+			request := toFriendRequest(existing)
+			h.hub.SendToAccount(accountID, PresenceMessage{
+				Type:      "friend_request_accepted",
+				AccountID: req.FriendID.String(),
+				Request:   &request,
+			})
+			c.JSON(http.StatusOK, existing)
+			return
+		}
 		if existing.Status == StatusAccepted {
 			c.JSON(http.StatusConflict, middleware.ErrorResponse{
 				Error:   "already_friends",
@@ -88,12 +185,38 @@ func (h *FriendsHandler) SendRequest(c *pulpgin.Context) {
 		return
 	}
 
+	// The addressee's privacy setting is checked only now, so a
+	// crossed request they sent themselves is still honoured above.
+	allowed, err := h.acceptsRequestFrom(ctx, req.FriendID, accountID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, middleware.ErrorResponse{
+			Error:   "requests_restricted",
+			Message: "This user is not accepting friend requests from you",
+		})
+		return
+	}
+
+	// A request this account cancelled earlier still holds the pair
+	// index; it gives way to the new one.
+	if _, err := h.db.NewDelete().
+		Model((*Friendship)(nil)).
+		Where("requester_id = ? AND addressee_id = ? AND status = ?", accountID, req.FriendID, StatusCancelled).
+		Exec(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+
 	now := time.Now().UTC()
 	friendship := Friendship{
 		ID:          uuid.New(),
 		RequesterID: accountID,
 		AddresseeID: req.FriendID,
 		Status:      StatusPending,
+		Message:     req.Message,
 		CreatedAt:   now,
 		UpdatedAt:   now,
 	}
@@ -106,6 +229,13 @@ func (h *FriendsHandler) SendRequest(c *pulpgin.Context) {
 		return
 	}
 
+	request := toFriendRequest(friendship)
+	h.hub.SendToAccount(req.FriendID, PresenceMessage{
+		Type:      "friend_request_received",
+		AccountID: accountID.String(),
+		Request:   &request,
+	})
+
 	c.JSON(http.StatusCreated, friendship)
 }
 
@@ -143,11 +273,15 @@ func (h *FriendsHandler) AcceptRequest(c *pulpgin.Context) {
 		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
 		return
 	}
+	if h.expired(friendship) {
+		c.JSON(http.StatusGone, middleware.ErrorResponse{
+			Error:   "request_expired",
+			Message: "Friend request has expired",
+		})
+		return
+	}
 
-	friendship.Status = StatusAccepted
-	friendship.UpdatedAt = time.Now().UTC()
-
-	if _, err := h.db.NewUpdate().Model(&friendship).WherePK().Exec(ctx); err != nil {
+	if err := h.accept(ctx, &friendship, accountID); err != nil {
 		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "update_failed"})
 		return
 	}
@@ -155,6 +289,26 @@ func (h *FriendsHandler) AcceptRequest(c *pulpgin.Context) {
 	c.JSON(http.StatusOK, pulpgin.H{"status": "accepted"})
 }
 
+// accept promotes a pending request addressed to accountID and tells
+// both sides the friendship formed.
+func (h *FriendsHandler) accept(ctx context.Context, friendship *Friendship, accountID uuid.UUID) error {
+	friendship.Status = StatusAccepted
+	friendship.UpdatedAt = time.Now().UTC()
+
+	if _, err := h.db.NewUpdate().Model(friendship).WherePK().Exec(ctx); err != nil {
+		return err
+	}
+
+	request := toFriendRequest(*friendship)
+	h.hub.SendToAccount(friendship.RequesterID, PresenceMessage{
+		Type:      "friend_request_accepted",
+		AccountID: accountID.String(),
+		Request:   &request,
+	})
+	h.hub.FriendshipFormed(friendship.RequesterID, accountID)
+	return nil
+}
+
 func (h *FriendsHandler) DeclineRequest(c *pulpgin.Context) {
 	accountID, err := uuid.Parse(c.GetString("account_id"))
 	if err != nil {
@@ -173,9 +327,27 @@ func (h *FriendsHandler) DeclineRequest(c *pulpgin.Context) {
 
 	ctx := c.Ctx()
 
+	// Load the row first so the requester can be told who declined.
+	var friendship Friendship
+	err = h.db.NewSelect().
+		Model(&friendship).
+		Where("id = ? AND addressee_id = ? AND status = ?", req.RequestID, accountID, StatusPending).
+		Scan(ctx)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, middleware.ErrorResponse{
+			Error:   "not_found",
+			Message: "Friend request not found or you are not the recipient",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+
 	result, err := h.db.NewDelete().
 		Model((*Friendship)(nil)).
-		Where("id = ? AND addressee_id = ? AND status = ?", req.RequestID, accountID, StatusPending).
+		Where("id = ? AND status = ?", friendship.ID, StatusPending).
 		Exec(ctx)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
@@ -191,9 +363,71 @@ func (h *FriendsHandler) DeclineRequest(c *pulpgin.Context) {
 		return
 	}
 
+	request := toFriendRequest(friendship)
+	h.hub.SendToAccount(friendship.RequesterID, PresenceMessage{
+		Type:      "friend_request_declined",
+		AccountID: accountID.String(),
+		Request:   &request,
+	})
+
 	c.JSON(http.StatusOK, pulpgin.H{"status": "declined"})
 }
 
+// CancelRequest withdraws a pending request the caller sent. The row
+// is kept as cancelled so it shows up in the request history.
+func (h *FriendsHandler) CancelRequest(c *pulpgin.Context) {
+	accountID, err := uuid.Parse(c.GetString("account_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_token", Message: "Malformed account_id in token"})
+		return
+	}
+
+	var req HandleRequestInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "request_id is required",
+		})
+		return
+	}
+
+	ctx := c.Ctx()
+
+	var friendship Friendship
+	err = h.db.NewSelect().
+		Model(&friendship).
+		Where("id = ? AND requester_id = ? AND status = ?", req.RequestID, accountID, StatusPending).
+		Scan(ctx)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, middleware.ErrorResponse{
+			Error:   "not_found",
+			Message: "Friend request not found or you are not the sender",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+
+	friendship.Status = StatusCancelled
+	friendship.UpdatedAt = time.Now().UTC()
+
+	if _, err := h.db.NewUpdate().Model(&friendship).WherePK().Exec(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "update_failed"})
+		return
+	}
+
+	request := toFriendRequest(friendship)
+	h.hub.SendToAccount(friendship.AddresseeID, PresenceMessage{
+		Type:      "friend_request_cancelled",
+		AccountID: accountID.String(),
+		Request:   &request,
+	})
+
+	c.JSON(http.StatusOK, pulpgin.H{"status": "cancelled"})
+}
+
 func (h *FriendsHandler) RemoveFriend(c *pulpgin.Context) {
 	accountID, err := uuid.Parse(c.GetString("account_id"))
 	if err != nil {
@@ -230,6 +464,9 @@ func (h *FriendsHandler) RemoveFriend(c *pulpgin.Context) {
 		return
 	}
 
+	h.hub.SendToAccount(friendID, PresenceMessage{Type: "friend_removed", AccountID: accountID.String()})
+	h.hub.FriendshipEnded(accountID, friendID)
+
 	c.JSON(http.StatusOK, pulpgin.H{"status": "removed"})
 }
 
@@ -239,20 +476,65 @@ func (h *FriendsHandler) ListFriends(c *pulpgin.Context) {
 		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_token", Message: "Malformed account_id in token"})
 		return
 	}
+	page, err := parsePageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_request", Message: err.Error()})
+		return
+	}
+	onlineOnly := c.Query("online_only") == "true"
 	ctx := c.Ctx()
 
-	var friendships []Friendship
-	err = h.db.NewSelect().
-		Model(&friendships).
-		Where("(requester_id = ? OR addressee_id = ?) AND status = ?",
-			accountID, accountID, StatusAccepted).
-		Scan(ctx)
+	var only []uuid.UUID
+	if onlineOnly {
+		// Resolve the online set up front so the filter runs in SQL
+		// and pages stay full.
+		friendIDs, err := h.ListFriendIDs(ctx, accountID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+			return
+		}
+		online, err := h.hub.VisibleOnline(accountID, friendIDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+			return
+		}
+		if len(online) == 0 {
+			c.JSON(http.StatusOK, pulpgin.H{"friends": []Friend{}, "next_cursor": nil})
+			return
+		}
+		only = online
+	}
+
+	friends, _, next, err := h.friendsPage(ctx, accountID, page, only)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
 		return
 	}
 
+	c.JSON(http.StatusOK, pulpgin.H{"friends": friends, "next_cursor": next})
+}
+
+// friendsPage loads one page of accountID's friends, ordered by when
+// each friendship formed, with last_seen_at filled in. A non-nil only
+// restricts the page to those friends. It backs both GET /friends and the internal list,
+// so the two keep the same shape and order.
+func (h *FriendsHandler) friendsPage(ctx context.Context, accountID uuid.UUID, page pageParams, only []uuid.UUID) ([]Friend, []uuid.UUID, *string, error) {
+	q := h.db.NewSelect().
+		Model((*Friendship)(nil)).
+		Where("(requester_id = ? OR addressee_id = ?) AND status = ?",
+			accountID, accountID, StatusAccepted)
+	if only != nil {
+		q = q.Where("(requester_id IN (?) OR addressee_id IN (?))", bun.In(only), bun.In(only))
+	}
+
+	var friendships []Friendship
+	if err := page.apply(q, "updated_at", "id").Scan(ctx, &friendships); err != nil {
+		return nil, nil, nil, err
+	}
+	friendships, next := nextPage(friendships, page, friendshipCursor)
+
 	friends := make([]Friend, 0, len(friendships))
+	friendIDs := make([]uuid.UUID, 0, len(friendships))
 	for _, f := range friendships {
 		friendAccountID := f.AddresseeID
 		if f.AddresseeID == accountID {
@@ -262,12 +544,18 @@ func (h *FriendsHandler) ListFriends(c *pulpgin.Context) {
 			AccountID: friendAccountID,
 			Since:     f.UpdatedAt,
 		})
+		friendIDs = append(friendIDs, friendAccountID)
 	}
 
-	c.JSON(http.StatusOK, pulpgin.H{"friends": friends})
+	if err := h.fillLastSeen(ctx, friends, friendIDs); err != nil {
+		return nil, nil, nil, err
+	}
+	return friends, friendIDs, next, nil
 }
 
-func (h *FriendsHandler) ListRequests(c *pulpgin.Context) {
+// ListOnlineFriends returns the caller's friends who are online right
+// now, with status and activity, for clients that can't keep /ws open.
+func (h *FriendsHandler) ListOnlineFriends(c *pulpgin.Context) {
 	accountID, err := uuid.Parse(c.GetString("account_id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_token", Message: "Malformed account_id in token"})
@@ -275,58 +563,154 @@ func (h *FriendsHandler) ListRequests(c *pulpgin.Context) {
 	}
 	ctx := c.Ctx()
 
-	var incomingRows []Friendship
-	if err := h.db.NewSelect().
-		Model(&incomingRows).
-		Where("addressee_id = ? AND status = ?", accountID, StatusPending).
-		Scan(ctx); err != nil {
+	friendIDs, err := h.ListFriendIDs(ctx, accountID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+	online, err := h.hub.OnlineFriends(accountID, friendIDs)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
 		return
 	}
 
-	incoming := make([]FriendRequest, 0, len(incomingRows))
-	for _, f := range incomingRows {
-		incoming = append(incoming, FriendRequest{
-			ID:            f.ID,
-			FromAccountID: f.RequesterID,
-			ToAccountID:   f.AddresseeID,
-			CreatedAt:     f.CreatedAt,
-		})
+	c.JSON(http.StatusOK, pulpgin.H{"friends": online, "count": len(online)})
+}
+
+// fillLastSeen sets LastSeenAt on each of friends, whose account IDs
+// are friendIDs in the same order.
+func (h *FriendsHandler) fillLastSeen(ctx context.Context, friends []Friend, friendIDs []uuid.UUID) error {
+	seen, err := h.lastSeen.Lookup(ctx, friendIDs)
+	if err != nil {
+		return err
 	}
+	// Friends who hide their presence don't get a last-online either.
+	hidden, err := h.settings.HiddenAccounts(ctx, friendIDs)
+	if err != nil {
+		return err
+	}
+	for i := range friends {
+		if at, ok := seen[friends[i].AccountID]; ok && !hidden[friends[i].AccountID] {
+			friends[i].LastSeenAt = &at
+		}
+	}
+	return nil
+}
 
-	var outgoingRows []Friendship
-	if err := h.db.NewSelect().
-		Model(&outgoingRows).
-		Where("requester_id = ? AND status = ?", accountID, StatusPending).
-		Scan(ctx); err != nil {
-		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+func (h *FriendsHandler) ListRequests(c *pulpgin.Context) {
+	accountID, err := uuid.Parse(c.GetString("account_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_token", Message: "Malformed account_id in token"})
 		return
 	}
+	page, err := parsePageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_request", Message: err.Error()})
+		return
+	}
+	ctx := c.Ctx()
+	h.sweepExpired(ctx)
 
-	outgoing := make([]FriendRequest, 0, len(outgoingRows))
-	for _, f := range outgoingRows {
-		outgoing = append(outgoing, FriendRequest{
-			ID:            f.ID,
-			FromAccountID: f.RequesterID,
-			ToAccountID:   f.AddresseeID,
-			CreatedAt:     f.CreatedAt,
+	// Incoming and outgoing requests are paged as one stream so a
+	// single cursor covers both lists; ?direction= narrows it and
+	// ?history=true mixes in cancelled requests.
+	statuses := []FriendshipStatus{StatusPending}
+	includeHistory := c.Query("history") == "true"
+	if includeHistory {
+		statuses = append(statuses, StatusCancelled)
+	}
+	q := h.db.NewSelect().
+		Model((*Friendship)(nil)).
+		Where("status IN (?)", bun.In(statuses))
+	switch c.Query("direction") {
+	case "":
+		q = q.Where("(requester_id = ? OR addressee_id = ?)", accountID, accountID)
+	case "incoming":
+		q = q.Where("addressee_id = ?", accountID)
+	case "outgoing":
+		q = q.Where("requester_id = ?", accountID)
+	default:
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "direction must be incoming or outgoing",
 		})
+		return
+	}
+	if cutoff, ok := h.expiryCutoff(); ok {
+		q = q.Where("created_at >= ?", cutoff)
+	}
+
+	var rows []Friendship
+	if err := page.apply(q, "updated_at", "id").Scan(ctx, &rows); err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+	rows, next := nextPage(rows, page, friendshipCursor)
+
+	incoming := make([]FriendRequest, 0, len(rows))
+	outgoing := make([]FriendRequest, 0, len(rows))
+	history := make([]FriendRequest, 0)
+	for _, f := range rows {
+		switch {
+		case f.Status == StatusCancelled:
+			history = append(history, toFriendRequest(f))
+		case f.AddresseeID == accountID:
+			incoming = append(incoming, toFriendRequest(f))
+		default:
+			outgoing = append(outgoing, toFriendRequest(f))
+		}
 	}
 
 	c.JSON(http.StatusOK, pulpgin.H{
-		"incoming": incoming,
-		"outgoing": outgoing,
+		"incoming":    incoming,
+		"outgoing":    outgoing,
+		"history":     history,
+		"next_cursor": next,
 	})
 }
 
 // RemoveFriendship is called internally by the blocks handler.
+// accountA is the account acting; accountB is told the friendship or
+// pending request is gone, the same way it would be if accountA had
+// removed, declined or withdrawn it by hand.
 func (h *FriendsHandler) RemoveFriendship(ctx context.Context, accountA, accountB uuid.UUID) error {
+	var rows []Friendship
+	if err := h.db.NewSelect().
+		Model(&rows).
+		Where("(requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)",
+			accountA, accountB, accountB, accountA).
+		Scan(ctx); err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
 	_, err := h.db.NewDelete().
 		Model((*Friendship)(nil)).
 		Where("(requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)",
 			accountA, accountB, accountB, accountA).
 		Exec(ctx)
-	return err
+	if err != nil {
+		return err
+	}
+
+	for _, f := range rows {
+		switch {
+		case f.Status == StatusCancelled || h.expired(f):
+			// Already withdrawn or lapsed; accountB has nothing live to lose.
+		case f.Status == StatusAccepted:
+			h.hub.SendToAccount(accountB, PresenceMessage{Type: "friend_removed", AccountID: accountA.String()})
+			h.hub.FriendshipEnded(accountA, accountB)
+		case f.RequesterID == accountB:
+			request := toFriendRequest(f)
+			h.hub.SendToAccount(accountB, PresenceMessage{Type: "friend_request_declined", AccountID: accountA.String(), Request: &request})
+		default:
+			request := toFriendRequest(f)
+			h.hub.SendToAccount(accountB, PresenceMessage{Type: "friend_request_cancelled", AccountID: accountA.String(), Request: &request})
+		}
+	}
+	return nil
 }
 
 // ListFriendIDs is used by the presence hub to know who to notify.
@@ -351,3 +735,75 @@ func (h *FriendsHandler) ListFriendIDs(ctx context.Context, accountID uuid.UUID)
 	}
 	return ids, nil
 }
+
+// acceptsRequestFrom applies the addressee's friend_requests setting
+// to a request from requesterID.
+func (h *FriendsHandler) acceptsRequestFrom(ctx context.Context, addresseeID, requesterID uuid.UUID) (bool, error) {
+	settings, err := h.settings.Load(ctx, addresseeID)
+	if err != nil {
+		return false, err
+	}
+	switch settings.FriendRequests {
+	case RequestsNobody:
+		return false, nil
+	case RequestsFriendsOfFriends:
+		return h.haveMutualFriend(ctx, addresseeID, requesterID)
+	default:
+		return true, nil
+	}
+}
+
+// expiryCutoff returns the creation time before which a request has
+// expired, or false when expiry is disabled.
+func (h *FriendsHandler) expiryCutoff() (time.Time, bool) {
+	if h.requestTTL <= 0 {
+		return time.Time{}, false
+	}
+	return time.Now().UTC().Add(-h.requestTTL), true
+}
+
+func (h *FriendsHandler) expired(f Friendship) bool {
+	cutoff, ok := h.expiryCutoff()
+	return ok && f.Status == StatusPending && f.CreatedAt.Before(cutoff)
+}
+
+// sweepExpired deletes requests older than the TTL, at most once per
+// requestSweepInterval. Withdrawn requests age out of the history on
+// the same schedule. The cell owns no goroutines, so this runs lazily
+// from the request handlers.
+func (h *FriendsHandler) sweepExpired(ctx context.Context) {
+	cutoff, ok := h.expiryCutoff()
+	if !ok {
+		return
+	}
+	h.sweepMu.Lock()
+	if time.Since(h.lastSweep) < requestSweepInterval {
+		h.sweepMu.Unlock()
+		return
+	}
+	h.lastSweep = time.Now()
+	h.sweepMu.Unlock()
+
+	if _, err := h.db.NewDelete().
+		Model((*Friendship)(nil)).
+		Where("status IN (?) AND created_at < ?", bun.In([]FriendshipStatus{StatusPending, StatusCancelled}), cutoff).
+		Exec(ctx); err != nil {
+		log.Printf("friends: failed to sweep expired requests: %v", err)
+	}
+}
+
+func toFriendRequest(f Friendship) FriendRequest {
+	return FriendRequest{
+		ID:            f.ID,
+		FromAccountID: f.RequesterID,
+		ToAccountID:   f.AddresseeID,
+		Status:        f.Status,
+		Message:       f.Message,
+		CreatedAt:     f.CreatedAt,
+		UpdatedAt:     f.UpdatedAt,
+	}
+}
+
+func friendshipCursor(f Friendship) pageCursor {
+	return pageCursor{At: f.UpdatedAt, ID: f.ID}
+}
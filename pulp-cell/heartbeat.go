@@ -0,0 +1,123 @@
+package main
+
+import (
+	"time"
+
+	"github.com/BananaLabs-OSS/Fiber/pulp"
+	"github.com/google/uuid"
+)
+
+// sessionSweepInterval throttles the last_seen refresh and the
+// heartbeat and idle checks. The host gives the cell no goroutines or
+// timers, so they only run when something calls in: a frame or a new
+// connection from any client, or a GET /health via Tick. A cell with
+// none of those does no sweeping at all — a frozen client stays online
+// until traffic resumes — so deployments relying on heartbeats should
+// poll /health at least every sessionSweepInterval.
+const sessionSweepInterval = 10 * time.Second
+
+// Heartbeat marks connID as a pinging session. From then on it is held
+// to the heartbeat timeout: going silent for longer gets it dropped.
+// Sessions that never ping are left to the host's socket state.
+func (h *Hub) Heartbeat(accountID uuid.UUID, connID uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if acct := h.sessionAccountLocked(accountID, connID); acct != nil {
+		acct.sessions[connID].pinging = true
+	}
+}
+
+// Active records that the player did something on connID. An account
+// the idle check set away comes back online.
+func (h *Hub) Active(accountID uuid.UUID, connID uint64) {
+	h.mu.Lock()
+	acct := h.sessionAccountLocked(accountID, connID)
+	if acct == nil {
+		h.mu.Unlock()
+		return
+	}
+	acct.sessions[connID].lastActive = time.Now()
+	if !acct.autoAway {
+		h.mu.Unlock()
+		return
+	}
+	before := acct.visible()
+	acct.autoAway = false
+	acct.status = PresenceOnline
+	after := acct.visible()
+	h.mu.Unlock()
+
+	h.notifyTransition(accountID, before, after)
+}
+
+// sweepSessions refreshes last_seen_at for visibly online accounts
+// whose last write is older than lastSeenTouchInterval, drops pinging
+// sessions that missed their heartbeat and sets away accounts whose
+// sessions have all been idle for idleTimeout. Only accounts showing
+// plain online, all of whose sessions have sent a command, are
+// auto-away'd, so a chosen busy or invisible status and clients that
+// only listen are left alone.
+func (h *Hub) sweepSessions(now time.Time) {
+	type stale struct {
+		accountID uuid.UUID
+		connID    uint64
+	}
+	type idle struct {
+		accountID     uuid.UUID
+		before, after PresenceStatus
+	}
+	var evict []stale
+	var away []idle
+	var seen []uuid.UUID
+
+	h.mu.Lock()
+	if now.Sub(h.lastSessionSweep) < sessionSweepInterval {
+		h.mu.Unlock()
+		return
+	}
+	h.lastSessionSweep = now
+	for id, acct := range h.accounts {
+		if acct.visible() != PresenceOffline && now.Sub(acct.lastTouch) >= lastSeenTouchInterval {
+			acct.lastTouch = now
+			seen = append(seen, id)
+		}
+		var lastActive time.Time
+		silent := false
+		for connID, s := range acct.sessions {
+			if h.heartbeatTimeout > 0 && s.pinging && now.Sub(s.lastFrame) > h.heartbeatTimeout {
+				evict = append(evict, stale{accountID: id, connID: connID})
+				continue
+			}
+			if s.lastActive.IsZero() {
+				// A client that never sends commands can't show it is
+				// in use, so its account is never auto-away'd.
+				silent = true
+			}
+			if s.lastActive.After(lastActive) {
+				lastActive = s.lastActive
+			}
+		}
+		if h.idleTimeout > 0 && acct.status == PresenceOnline && !silent && !lastActive.IsZero() && now.Sub(lastActive) > h.idleTimeout {
+			before := acct.visible()
+			acct.status = PresenceAway
+			acct.autoAway = true
+			away = append(away, idle{accountID: id, before: before, after: acct.visible()})
+		}
+	}
+	h.mu.Unlock()
+
+	for _, id := range seen {
+		h.recordLastSeen(id, now.UTC())
+	}
+	for _, a := range away {
+		h.notifyTransition(a.accountID, a.before, a.after)
+	}
+	for _, s := range evict {
+		_ = pulp.WS.Close(pulp.WSCloseRequest{
+			ConnID: s.connID,
+			Code:   1001,
+			Reason: "heartbeat timeout",
+		})
+		h.Unregister(s.accountID, s.connID)
+	}
+}
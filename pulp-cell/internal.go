@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	pulpgin "github.com/BananaLabs-OSS/Fiber/pulp/gin"
+	"github.com/BananaLabs-OSS/Fiber/pulp/gin/middleware"
+	"github.com/google/uuid"
+)
+
+// maxBulkFriendLists caps how many accounts one bulk friends query may
+// cover.
+const maxBulkFriendLists = 100
+
+// InternalListFriends returns an account's friends to internal
+// services, paginated like GET /friends. With ?presence=true each
+// friend carries its presence as /internal/presence reports it.
+func (h *FriendsHandler) InternalListFriends(c *pulpgin.Context) {
+	accountID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid account ID",
+		})
+		return
+	}
+	page, err := parsePageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_request", Message: err.Error()})
+		return
+	}
+	withPresence := c.Query("presence") == "true"
+	ctx := c.Ctx()
+
+	friends, friendIDs, next, err := h.friendsPage(ctx, accountID, page, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+	if withPresence {
+		presence := h.hub.BulkPresence(friendIDs)
+		for i := range friends {
+			info := presence[friends[i].AccountID]
+			friends[i].Presence = &info
+		}
+	}
+
+	c.JSON(http.StatusOK, pulpgin.H{"account_id": accountID, "friends": friends, "next_cursor": next})
+}
+
+// InternalBulkFriends returns the friend IDs of several accounts at
+// once. Lists are complete rather than paginated; with "presence" set,
+// a single presence map covers every friend in them.
+func (h *FriendsHandler) InternalBulkFriends(c *pulpgin.Context) {
+	var req struct {
+		AccountIDs []uuid.UUID `json:"account_ids" binding:"required"`
+		Presence   bool        `json:"presence"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "account_ids is required",
+		})
+		return
+	}
+	if len(req.AccountIDs) > maxBulkFriendLists {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_request",
+			Message: fmt.Sprintf("account_ids may list at most %d accounts", maxBulkFriendLists),
+		})
+		return
+	}
+	ctx := c.Ctx()
+
+	lists := make(map[string][]uuid.UUID, len(req.AccountIDs))
+	var everyone []uuid.UUID
+	seen := map[uuid.UUID]bool{}
+	for _, id := range req.AccountIDs {
+		friendIDs, err := h.ListFriendIDs(ctx, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+			return
+		}
+		lists[id.String()] = friendIDs
+		for _, friendID := range friendIDs {
+			if !seen[friendID] {
+				seen[friendID] = true
+				everyone = append(everyone, friendID)
+			}
+		}
+	}
+
+	resp := pulpgin.H{"friends": lists}
+	if req.Presence {
+		presence := make(map[string]PresenceInfo, len(everyone))
+		for id, info := range h.hub.BulkPresence(everyone) {
+			presence[id.String()] = info
+		}
+		resp["presence"] = presence
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// lastSeenTouchInterval is how often a connected account's last_seen_at
+// is refreshed, so a cell restart without clean disconnects loses at
+// most this much.
+const lastSeenTouchInterval = 5 * time.Minute
+
+// LastSeenStore persists when each account was last visibly online.
+type LastSeenStore struct {
+	db *bun.DB
+}
+
+func NewLastSeenStore(db *bun.DB) *LastSeenStore {
+	return &LastSeenStore{db: db}
+}
+
+// Touch records that accountID was online at the given time.
+func (s *LastSeenStore) Touch(ctx context.Context, accountID uuid.UUID, at time.Time) error {
+	row := LastSeen{AccountID: accountID, LastSeenAt: at}
+	_, err := s.db.NewInsert().
+		Model(&row).
+		On("CONFLICT (account_id) DO UPDATE").
+		Set("last_seen_at = EXCLUDED.last_seen_at").
+		Exec(ctx)
+	return err
+}
+
+// Lookup returns the recorded last_seen_at of each account that has
+// one. Accounts that were never seen are absent from the map.
+func (s *LastSeenStore) Lookup(ctx context.Context, accountIDs []uuid.UUID) (map[uuid.UUID]time.Time, error) {
+	result := make(map[uuid.UUID]time.Time, len(accountIDs))
+	if len(accountIDs) == 0 {
+		return result, nil
+	}
+	var rows []LastSeen
+	if err := s.db.NewSelect().
+		Model(&rows).
+		Where("account_id IN (?)", bun.In(accountIDs)).
+		Scan(ctx); err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		result[r.AccountID] = r.LastSeenAt
+	}
+	return result, nil
+}
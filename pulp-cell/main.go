@@ -17,6 +17,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/BananaLabs-OSS/Fiber/pulp"
 	pulpgin "github.com/BananaLabs-OSS/Fiber/pulp/gin"
@@ -55,14 +56,23 @@ func bootstrap(configBytes []byte) error {
 		return fmt.Errorf("migrate: %w", err)
 	}
 
-	friends := NewFriendsHandler(db)
+	lastSeen := NewLastSeenStore(db)
+	settingsStore := NewSettingsStore(db)
+	friends := NewFriendsHandler(db, lastSeen, settingsStore, cfg.requestTTL, newWordListFilter(cfg.MessageBlocklist))
 	blocks := NewBlocksHandler(db, friends)
-	hub := NewHub(friends)
-	presence := NewPresenceHandler(hub, []byte(cfg.JWTSecret))
+	muteStore := NewMuteStore(db)
+	hub := NewHub(friends, lastSeen, settingsStore, muteStore, cfg.heartbeatTimeout, cfg.idleTimeout)
+	friends.SetHub(hub)
+	presence := NewPresenceHandler(hub, lastSeen, settingsStore, []byte(cfg.JWTSecret))
+	settings := NewSettingsHandler(settingsStore, hub)
+	suggestions := NewSuggestionsHandler(db)
+	recent := NewRecentHandler(db, friends)
+	mutes := NewMutesHandler(db, hub)
 
 	r := pulpgin.New()
 
 	r.GET("/health", func(c *pulpgin.Context) {
+		hub.Tick()
 		c.JSON(http.StatusOK, pulpgin.H{
 			"service":      "bunch",
 			"status":       "healthy",
@@ -82,21 +92,41 @@ func bootstrap(configBytes []byte) error {
 	f.POST("/request", friends.SendRequest)
 	f.POST("/accept", friends.AcceptRequest)
 	f.POST("/decline", friends.DeclineRequest)
+	f.POST("/cancel", friends.CancelRequest)
 	f.DELETE("/:friendId", friends.RemoveFriend)
 	f.GET("", friends.ListFriends)
 	f.GET("/requests", friends.ListRequests)
+	f.GET("/online", friends.ListOnlineFriends)
+	f.GET("/mutual/:accountId", friends.ListMutual)
+	f.GET("/suggestions", suggestions.ListSuggestions)
+
+	authed.GET("/settings", settings.GetSettings)
+	authed.PUT("/settings", settings.UpdateSettings)
+
+	authed.GET("/recent", recent.ListRecent)
 
 	b := authed.Group("/blocks")
 	b.POST("", blocks.BlockUser)
 	b.DELETE("/:accountId", blocks.UnblockUser)
 	b.GET("", blocks.ListBlocked)
 
+	m := authed.Group("/mutes")
+	m.POST("", mutes.MuteUser)
+	m.DELETE("/:accountId", mutes.UnmuteUser)
+	m.GET("", mutes.ListMuted)
+
 	// Internal service routes.
 	internal := r.Group("/internal")
 	internal.Use(middleware.ServiceAuth(cfg.ServiceSecret))
 	internal.GET("/presence/:userId", presence.GetPresence)
 	internal.POST("/presence/bulk", presence.BulkPresence)
 	internal.GET("/presence/count", presence.OnlineCount)
+	internal.GET("/friends/:userId", friends.InternalListFriends)
+	internal.POST("/friends/bulk", friends.InternalBulkFriends)
+	internal.POST("/matches", recent.RecordMatch)
+	internal.GET("/relationships/:accountId/:otherId", friends.GetRelationship)
+	internal.POST("/relationships/bulk", friends.BulkRelationships)
+	internal.GET("/blocks/:accountId", blocks.BlockReport)
 
 	if err := r.Run(); err != nil {
 		return fmt.Errorf("router: %w", err)
@@ -111,6 +141,7 @@ func migrate(ctx context.Context) error {
 			requester_id TEXT NOT NULL,
 			addressee_id TEXT NOT NULL,
 			status TEXT NOT NULL,
+			message TEXT,
 			created_at TIMESTAMP NOT NULL,
 			updated_at TIMESTAMP NOT NULL
 		)`,
@@ -118,25 +149,99 @@ func migrate(ctx context.Context) error {
 			id TEXT PRIMARY KEY,
 			blocker_id TEXT NOT NULL,
 			blocked_id TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS block_reports (
+			id TEXT PRIMARY KEY,
+			blocker_id TEXT NOT NULL,
+			blocked_id TEXT NOT NULL,
+			reason TEXT,
+			note TEXT,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS mutes (
+			id TEXT PRIMARY KEY,
+			muter_id TEXT NOT NULL,
+			muted_id TEXT NOT NULL,
 			created_at TIMESTAMP NOT NULL
 		)`,
+		`CREATE TABLE IF NOT EXISTS last_seen (
+			account_id TEXT PRIMARY KEY,
+			last_seen_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS account_settings (
+			account_id TEXT PRIMARY KEY,
+			friend_requests TEXT NOT NULL,
+			presence_visibility TEXT NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS recent_players (
+			account_id TEXT NOT NULL,
+			player_id TEXT NOT NULL,
+			match_id TEXT,
+			played_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (account_id, player_id)
+		)`,
 		`CREATE INDEX IF NOT EXISTS idx_friendships_requester ON friendships (requester_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_friendships_addressee ON friendships (addressee_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_friendships_status ON friendships (status)`,
 		`CREATE UNIQUE INDEX IF NOT EXISTS idx_friendships_pair ON friendships (requester_id, addressee_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_blocks_blocker ON blocks (blocker_id)`,
 		`CREATE UNIQUE INDEX IF NOT EXISTS idx_blocks_pair ON blocks (blocker_id, blocked_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_block_reports_blocked ON block_reports (blocked_id, created_at)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_mutes_pair ON mutes (muter_id, muted_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_recent_players_played ON recent_players (account_id, played_at)`,
 	}
 	for _, s := range stmts {
 		if _, err := db.ExecContext(ctx, s); err != nil {
 			return fmt.Errorf("migrate exec: %w", err)
 		}
 	}
+
+	// Columns added after a table first shipped. CREATE TABLE above
+	// already has them for fresh databases; older ones get them here.
+	columns := []struct{ table, column, ddl string }{
+		{"friendships", "message", "message TEXT"},
+		{"blocks", "expires_at", "expires_at TIMESTAMP"},
+	}
+	for _, col := range columns {
+		if err := addColumn(ctx, col.table, col.column, col.ddl); err != nil {
+			return fmt.Errorf("migrate %s.%s: %w", col.table, col.column, err)
+		}
+	}
 	return nil
 }
 
+// addColumn adds a column to an existing table unless it is already
+// there. SQLite has no ADD COLUMN IF NOT EXISTS.
+func addColumn(ctx context.Context, table, column, ddl string) error {
+	var names []string
+	if err := db.NewRaw("SELECT name FROM pragma_table_info(?)", table).Scan(ctx, &names); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name == column {
+			return nil
+		}
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, ddl))
+	return err
+}
+
+// defaultFriendRequestTTL is how long a pending friend request lives
+// when the manifest doesn't set friend_request_ttl.
+const defaultFriendRequestTTL = 30 * 24 * time.Hour
+
+// Defaults for heartbeat_timeout and idle_timeout.
+const (
+	defaultHeartbeatTimeout = 90 * time.Second
+	defaultIdleTimeout      = 10 * time.Minute
+)
+
 type config struct {
-	JWTSecret    string `json:"jwt_secret"`
+	JWTSecret string `json:"jwt_secret"`
 	// ServiceSecret is the /internal-route auth token. Aliased to
 	// `service_token` in manifests for backwards compatibility; the
 	// canonical key is `service_secret` so the config name matches the
@@ -145,6 +250,26 @@ type config struct {
 	// ServiceTokenAlias preserves older manifests that wrote
 	// `service_token = "..."` under the wrong key.
 	ServiceTokenAlias string `json:"service_token"`
+	// MessageBlocklist lists case-insensitive substrings that get a
+	// friend request message rejected.
+	MessageBlocklist []string `json:"message_blocklist"`
+	// FriendRequestTTL is how long a pending friend request lives
+	// before it expires, as a Go duration ("720h"). "0" disables
+	// expiry.
+	FriendRequestTTL string `json:"friend_request_ttl"`
+	// HeartbeatTimeout is how long a /ws session that has started
+	// pinging may go without sending a frame before it is dropped.
+	// "0" disables the check.
+	HeartbeatTimeout string `json:"heartbeat_timeout"`
+	// IdleTimeout is how long an online account's sessions may all go
+	// without a command before it is set away. "0" disables auto-away.
+	IdleTimeout string `json:"idle_timeout"`
+
+	// requestTTL is FriendRequestTTL parsed.
+	requestTTL time.Duration
+	// heartbeatTimeout and idleTimeout are the parsed timeouts.
+	heartbeatTimeout time.Duration
+	idleTimeout      time.Duration
 }
 
 func parseConfig(data []byte) (config, error) {
@@ -181,5 +306,30 @@ func parseConfig(data []byte) (config, error) {
 	if cfg.ServiceSecret == "" {
 		cfg.ServiceSecret = "dev-service-secret"
 	}
+	if cfg.requestTTL, err = parseDuration(cfg.FriendRequestTTL, defaultFriendRequestTTL); err != nil {
+		return cfg, fmt.Errorf("friend_request_ttl: %w", err)
+	}
+	if cfg.heartbeatTimeout, err = parseDuration(cfg.HeartbeatTimeout, defaultHeartbeatTimeout); err != nil {
+		return cfg, fmt.Errorf("heartbeat_timeout: %w", err)
+	}
+	if cfg.idleTimeout, err = parseDuration(cfg.IdleTimeout, defaultIdleTimeout); err != nil {
+		return cfg, fmt.Errorf("idle_timeout: %w", err)
+	}
 	return cfg, nil
 }
+
+// parseDuration reads an optional duration setting, falling back to def
+// when it is unset.
+func parseDuration(value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("must not be negative")
+	}
+	return d, nil
+}
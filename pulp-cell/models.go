@@ -10,10 +10,74 @@ import (
 type FriendshipStatus string
 
 const (
-	StatusPending  FriendshipStatus = "pending"
-	StatusAccepted FriendshipStatus = "accepted"
+	StatusPending   FriendshipStatus = "pending"
+	StatusAccepted  FriendshipStatus = "accepted"
+	StatusCancelled FriendshipStatus = "cancelled"
 )
 
+// FriendRequestPolicy controls who may send an account friend
+// requests.
+type FriendRequestPolicy string
+
+const (
+	RequestsEveryone         FriendRequestPolicy = "everyone"
+	RequestsFriendsOfFriends FriendRequestPolicy = "friends_of_friends"
+	RequestsNobody           FriendRequestPolicy = "nobody"
+)
+
+func (p FriendRequestPolicy) Valid() bool {
+	switch p {
+	case RequestsEveryone, RequestsFriendsOfFriends, RequestsNobody:
+		return true
+	}
+	return false
+}
+
+// PresenceVisibility controls who can see an account online.
+type PresenceVisibility string
+
+const (
+	VisibilityFriends PresenceVisibility = "friends"
+	VisibilityNobody  PresenceVisibility = "nobody"
+)
+
+func (v PresenceVisibility) Valid() bool {
+	return v == VisibilityFriends || v == VisibilityNobody
+}
+
+// Relationship is how one account stands with another, from the first
+// account's side.
+type Relationship string
+
+const (
+	RelationNone            Relationship = "none"
+	RelationFriends         Relationship = "friends"
+	RelationPendingOutgoing Relationship = "pending_outgoing"
+	RelationPendingIncoming Relationship = "pending_incoming"
+	RelationBlocked         Relationship = "blocked"
+	RelationBlockedBy       Relationship = "blocked_by"
+)
+
+// BlockReason is the optional category a player gives for a block.
+// It is passed on to moderation, never shown to the blocked account.
+type BlockReason string
+
+const (
+	ReasonSpam          BlockReason = "spam"
+	ReasonHarassment    BlockReason = "harassment"
+	ReasonCheating      BlockReason = "cheating"
+	ReasonInappropriate BlockReason = "inappropriate"
+	ReasonOther         BlockReason = "other"
+)
+
+func (r BlockReason) Valid() bool {
+	switch r {
+	case ReasonSpam, ReasonHarassment, ReasonCheating, ReasonInappropriate, ReasonOther:
+		return true
+	}
+	return false
+}
+
 type Friendship struct {
 	bun.BaseModel `bun:"table:friendships,alias:f"`
 
@@ -21,6 +85,7 @@ type Friendship struct {
 	RequesterID uuid.UUID        `bun:"requester_id,notnull,type:uuid" json:"requester_id"`
 	AddresseeID uuid.UUID        `bun:"addressee_id,notnull,type:uuid" json:"addressee_id"`
 	Status      FriendshipStatus `bun:"status,notnull" json:"status"`
+	Message     string           `bun:"message,nullzero" json:"message,omitempty"`
 	CreatedAt   time.Time        `bun:"created_at,nullzero,notnull" json:"created_at"`
 	UpdatedAt   time.Time        `bun:"updated_at,nullzero,notnull" json:"updated_at"`
 }
@@ -32,27 +97,111 @@ type Block struct {
 	BlockerID uuid.UUID `bun:"blocker_id,notnull,type:uuid" json:"blocker_id"`
 	BlockedID uuid.UUID `bun:"blocked_id,notnull,type:uuid" json:"blocked_id"`
 	CreatedAt time.Time `bun:"created_at,nullzero,notnull" json:"created_at"`
+	// ExpiresAt ends a temporary block; nil blocks until unblocked.
+	ExpiresAt *time.Time `bun:"expires_at,nullzero" json:"expires_at,omitempty"`
+}
+
+// BlockReportEntry records a block as it was placed, with the reason
+// and note given. Rows are only ever inserted, so moderation keeps the
+// history after the block itself is lifted, lapses or is swept.
+type BlockReportEntry struct {
+	bun.BaseModel `bun:"table:block_reports,alias:br"`
+
+	ID        uuid.UUID   `bun:"id,pk,type:uuid" json:"id"`
+	BlockerID uuid.UUID   `bun:"blocker_id,notnull,type:uuid" json:"blocker_id"`
+	BlockedID uuid.UUID   `bun:"blocked_id,notnull,type:uuid" json:"blocked_id"`
+	Reason    BlockReason `bun:"reason,nullzero" json:"reason,omitempty"`
+	Note      string      `bun:"note,nullzero" json:"note,omitempty"`
+	CreatedAt time.Time   `bun:"created_at,nullzero,notnull" json:"created_at"`
+	ExpiresAt *time.Time  `bun:"expires_at,nullzero" json:"expires_at,omitempty"`
+}
+
+type Mute struct {
+	bun.BaseModel `bun:"table:mutes,alias:m"`
+
+	ID        uuid.UUID `bun:"id,pk,type:uuid" json:"id"`
+	MuterID   uuid.UUID `bun:"muter_id,notnull,type:uuid" json:"muter_id"`
+	MutedID   uuid.UUID `bun:"muted_id,notnull,type:uuid" json:"muted_id"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull" json:"created_at"`
+}
+
+type LastSeen struct {
+	bun.BaseModel `bun:"table:last_seen,alias:ls"`
+
+	AccountID  uuid.UUID `bun:"account_id,pk,type:uuid" json:"account_id"`
+	LastSeenAt time.Time `bun:"last_seen_at,notnull" json:"last_seen_at"`
+}
+
+// RecentPlay records that AccountID shared a match with PlayerID. There
+// is one row per pair, moved forward each time they play again.
+type RecentPlay struct {
+	bun.BaseModel `bun:"table:recent_players,alias:rp"`
+
+	AccountID uuid.UUID `bun:"account_id,pk,type:uuid" json:"account_id"`
+	PlayerID  uuid.UUID `bun:"player_id,pk,type:uuid" json:"player_id"`
+	MatchID   string    `bun:"match_id,nullzero" json:"match_id,omitempty"`
+	PlayedAt  time.Time `bun:"played_at,notnull" json:"played_at"`
+}
+
+type AccountSettings struct {
+	bun.BaseModel `bun:"table:account_settings,alias:s"`
+
+	AccountID          uuid.UUID           `bun:"account_id,pk,type:uuid" json:"account_id"`
+	FriendRequests     FriendRequestPolicy `bun:"friend_requests,notnull" json:"friend_requests"`
+	PresenceVisibility PresenceVisibility  `bun:"presence_visibility,notnull" json:"presence_visibility"`
+	UpdatedAt          time.Time           `bun:"updated_at,nullzero,notnull" json:"updated_at,omitzero"`
 }
 
 type Friend struct {
-	AccountID uuid.UUID `json:"account_id"`
-	Since     time.Time `json:"since"`
+	AccountID  uuid.UUID  `json:"account_id"`
+	Since      time.Time  `json:"since"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+	// Presence is only filled in for internal callers that ask for it.
+	Presence *PresenceInfo `json:"presence,omitempty"`
 }
 
 type FriendRequest struct {
-	ID            uuid.UUID `json:"id"`
-	FromAccountID uuid.UUID `json:"from_account_id"`
-	ToAccountID   uuid.UUID `json:"to_account_id"`
-	CreatedAt     time.Time `json:"created_at"`
+	ID            uuid.UUID        `json:"id"`
+	FromAccountID uuid.UUID        `json:"from_account_id"`
+	ToAccountID   uuid.UUID        `json:"to_account_id"`
+	Status        FriendshipStatus `json:"status"`
+	Message       string           `json:"message,omitempty"`
+	CreatedAt     time.Time        `json:"created_at"`
+	UpdatedAt     time.Time        `json:"updated_at"`
 }
 
 type BlockedUser struct {
+	AccountID uuid.UUID  `json:"account_id"`
+	Since     time.Time  `json:"since"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// RemainingSeconds is how long a temporary block has left.
+	RemainingSeconds *int64 `json:"remaining_seconds,omitempty"`
+}
+
+// BlockRecord is one block against an account as moderation sees it.
+type BlockRecord struct {
+	BlockerID uuid.UUID   `json:"blocker_id"`
+	Reason    BlockReason `json:"reason,omitempty"`
+	Note      string      `json:"note,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+	ExpiresAt *time.Time  `json:"expires_at,omitempty"`
+}
+
+type MutedUser struct {
 	AccountID uuid.UUID `json:"account_id"`
 	Since     time.Time `json:"since"`
 }
 
+type RecentPlayer struct {
+	AccountID    uuid.UUID    `json:"account_id"`
+	MatchID      string       `json:"match_id,omitempty"`
+	PlayedAt     time.Time    `json:"played_at"`
+	Relationship Relationship `json:"relationship"`
+}
+
 type SendRequestInput struct {
 	FriendID uuid.UUID `json:"friend_id" binding:"required"`
+	Message  string    `json:"message"`
 }
 
 type HandleRequestInput struct {
@@ -61,4 +210,26 @@ type HandleRequestInput struct {
 
 type BlockInput struct {
 	AccountID uuid.UUID `json:"account_id" binding:"required"`
+	// Duration ("24h") or ExpiresAt makes the block temporary. At most
+	// one may be set.
+	Duration  string     `json:"duration"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	// Reason and Note are passed on to moderation. A note without a
+	// reason is filed as "other".
+	Reason BlockReason `json:"reason"`
+	Note   string      `json:"note"`
+}
+
+type MuteInput struct {
+	AccountID uuid.UUID `json:"account_id" binding:"required"`
+}
+
+type SettingsInput struct {
+	FriendRequests     *FriendRequestPolicy `json:"friend_requests"`
+	PresenceVisibility *PresenceVisibility  `json:"presence_visibility"`
+}
+
+type MatchInput struct {
+	MatchID string      `json:"match_id"`
+	Players []uuid.UUID `json:"players" binding:"required"`
 }
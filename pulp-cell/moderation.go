@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	pulpgin "github.com/BananaLabs-OSS/Fiber/pulp/gin"
+	"github.com/BananaLabs-OSS/Fiber/pulp/gin/middleware"
+	"github.com/google/uuid"
+)
+
+// defaultReportWindow is how far back BlockReport looks when the
+// caller doesn't pass ?since=.
+const defaultReportWindow = 30 * 24 * time.Hour
+
+// reportWindowExpr selects the block reports against one account
+// created in [since, until).
+const reportWindowExpr = "blocked_id = ? AND created_at >= ? AND created_at < ?"
+
+// BlockReport gives moderation the blocks placed against an account
+// within a time window: how many distinct accounts blocked it, the
+// same count per reason, and the blocks themselves with their notes.
+// It reads block_reports, so blocks since lifted, lapsed or swept
+// still count.
+func (h *BlocksHandler) BlockReport(c *pulpgin.Context) {
+	blockedID, err := uuid.Parse(c.Param("accountId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid account ID",
+		})
+		return
+	}
+
+	until := time.Now().UTC()
+	if raw := c.Query("until"); raw != "" {
+		if until, err = time.Parse(time.RFC3339, raw); err != nil {
+			c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_request", Message: "until must be an RFC 3339 time"})
+			return
+		}
+	}
+	since := until.Add(-defaultReportWindow)
+	if raw := c.Query("since"); raw != "" {
+		if since, err = time.Parse(time.RFC3339, raw); err != nil {
+			c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_request", Message: "since must be an RFC 3339 time"})
+			return
+		}
+	}
+	since, until = since.UTC(), until.UTC()
+	if !since.Before(until) {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_request", Message: "since must be before until"})
+		return
+	}
+
+	page, err := parsePageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_request", Message: err.Error()})
+		return
+	}
+	ctx := c.Ctx()
+
+	var distinct int
+	if err := h.db.NewSelect().
+		Model((*BlockReportEntry)(nil)).
+		ColumnExpr("COUNT(DISTINCT blocker_id)").
+		Where(reportWindowExpr, blockedID, since, until).
+		Scan(ctx, &distinct); err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+
+	var perReason []struct {
+		Reason   BlockReason `bun:"reason"`
+		Blockers int         `bun:"blockers"`
+	}
+	if err := h.db.NewSelect().
+		Model((*BlockReportEntry)(nil)).
+		ColumnExpr("COALESCE(reason, '') AS reason").
+		ColumnExpr("COUNT(DISTINCT blocker_id) AS blockers").
+		Where(reportWindowExpr, blockedID, since, until).
+		GroupExpr("COALESCE(reason, '')").
+		Scan(ctx, &perReason); err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+	reasons := make(map[string]int, len(perReason))
+	for _, r := range perReason {
+		key := string(r.Reason)
+		if key == "" {
+			key = "unspecified"
+		}
+		reasons[key] = r.Blockers
+	}
+
+	q := h.db.NewSelect().
+		Model((*BlockReportEntry)(nil)).
+		Where(reportWindowExpr, blockedID, since, until)
+
+	var entries []BlockReportEntry
+	if err := page.apply(q, "created_at", "id").Scan(ctx, &entries); err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+	entries, next := nextPage(entries, page, blockReportCursor)
+
+	records := make([]BlockRecord, 0, len(entries))
+	for _, b := range entries {
+		records = append(records, BlockRecord{
+			BlockerID: b.BlockerID,
+			Reason:    b.Reason,
+			Note:      b.Note,
+			CreatedAt: b.CreatedAt,
+			ExpiresAt: b.ExpiresAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, pulpgin.H{
+		"account_id":        blockedID,
+		"since":             since,
+		"until":             until,
+		"distinct_blockers": distinct,
+		"reasons":           reasons,
+		"blocks":            records,
+		"next_cursor":       next,
+	})
+}
+
+func blockReportCursor(b BlockReportEntry) pageCursor {
+	return pageCursor{At: b.CreatedAt, ID: b.ID}
+}
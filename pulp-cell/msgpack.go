@@ -1,7 +1,101 @@
 package main
 
-import "github.com/vmihailenco/msgpack/v5"
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+
+	"github.com/BananaLabs-OSS/Fiber/pulp"
+	"github.com/google/uuid"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func init() {
+	// Account IDs travel as strings in msgpack frames too, matching
+	// JSON. uuid.UUID would otherwise go out as 16 raw bytes through
+	// its BinaryMarshaler.
+	msgpack.Register(uuid.UUID{},
+		func(e *msgpack.Encoder, v reflect.Value) error {
+			return e.EncodeString(v.Interface().(uuid.UUID).String())
+		},
+		func(d *msgpack.Decoder, v reflect.Value) error {
+			s, err := d.DecodeString()
+			if err != nil {
+				return err
+			}
+			id, err := uuid.Parse(s)
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(id))
+			return nil
+		})
+}
 
 func decodeMsgpack(data []byte, v any) error {
 	return msgpack.Unmarshal(data, v)
 }
+
+// wireEncoding is how a /ws session's frames are encoded, picked with
+// ?encoding= on connect. Both encodings share the JSON field names.
+type wireEncoding string
+
+const (
+	encodingJSON    wireEncoding = "json"
+	encodingMsgpack wireEncoding = "msgpack"
+)
+
+func (e wireEncoding) valid() bool {
+	return e == encodingJSON || e == encodingMsgpack
+}
+
+func (e wireEncoding) marshal(v any) ([]byte, error) {
+	if e != encodingMsgpack {
+		return json.Marshal(v)
+	}
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (e wireEncoding) unmarshal(data []byte, v any) error {
+	if e != encodingMsgpack {
+		return json.Unmarshal(data, v)
+	}
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// frame wraps an encoded payload for ws_send: text frames for JSON,
+// binary frames for msgpack.
+func (e wireEncoding) frame(connID uint64, data []byte) pulp.WSSendRequest {
+	req := pulp.WSSendRequest{ConnID: connID, OpCode: pulp.WSOpCodeText, Payload: data}
+	if e == encodingMsgpack {
+		req.OpCode = pulp.WSOpCodeBinary
+	}
+	return req
+}
+
+// rawPayload holds a command payload undecoded, in whichever encoding
+// its frame used, until the command type says what it should be.
+type rawPayload []byte
+
+func (r *rawPayload) UnmarshalJSON(data []byte) error {
+	*r = append((*r)[:0], data...)
+	return nil
+}
+
+func (r *rawPayload) DecodeMsgpack(dec *msgpack.Decoder) error {
+	raw, err := dec.DecodeRaw()
+	if err != nil {
+		return err
+	}
+	*r = rawPayload(raw)
+	return nil
+}
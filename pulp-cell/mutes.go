@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	pulpgin "github.com/BananaLabs-OSS/Fiber/pulp/gin"
+	"github.com/BananaLabs-OSS/Fiber/pulp/gin/middleware"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// MuteStore reads who an account has muted.
+type MuteStore struct {
+	db *bun.DB
+}
+
+func NewMuteStore(db *bun.DB) *MuteStore {
+	return &MuteStore{db: db}
+}
+
+// MutedIDs returns the accounts muterID has muted.
+func (s *MuteStore) MutedIDs(ctx context.Context, muterID uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := s.db.NewSelect().
+		Model((*Mute)(nil)).
+		Column("muted_id").
+		Where("muter_id = ?", muterID).
+		Scan(ctx, &ids)
+	return ids, err
+}
+
+// MuteSetter is told when an account mutes or unmutes someone.
+// Implemented by Hub.
+type MuteSetter interface {
+	SetMuted(muterID, mutedID uuid.UUID, muted bool)
+}
+
+// MutesHandler manages mutes: unlike a block, a mute leaves the
+// friendship alone and only stops the muted account's presence,
+// activity and friend request pushes reaching the muter.
+type MutesHandler struct {
+	db  *bun.DB
+	hub MuteSetter
+}
+
+func NewMutesHandler(db *bun.DB, hub MuteSetter) *MutesHandler {
+	return &MutesHandler{db: db, hub: hub}
+}
+
+func (h *MutesHandler) MuteUser(c *pulpgin.Context) {
+	muterID, err := uuid.Parse(c.GetString("account_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_token", Message: "Malformed account_id in token"})
+		return
+	}
+
+	var req MuteInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "account_id is required",
+		})
+		return
+	}
+
+	if muterID == req.AccountID {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "self_mute",
+			Message: "Cannot mute yourself",
+		})
+		return
+	}
+
+	ctx := c.Ctx()
+
+	exists, err := h.db.NewSelect().
+		Model((*Mute)(nil)).
+		Where("muter_id = ? AND muted_id = ?", muterID, req.AccountID).
+		Exists(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+	if exists {
+		c.JSON(http.StatusConflict, middleware.ErrorResponse{
+			Error:   "already_muted",
+			Message: "User already muted",
+		})
+		return
+	}
+
+	mute := Mute{
+		ID:        uuid.New(),
+		MuterID:   muterID,
+		MutedID:   req.AccountID,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if _, err := h.db.NewInsert().Model(&mute).Exec(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "creation_failed"})
+		return
+	}
+
+	h.hub.SetMuted(muterID, req.AccountID, true)
+
+	c.JSON(http.StatusCreated, pulpgin.H{"status": "muted"})
+}
+
+func (h *MutesHandler) UnmuteUser(c *pulpgin.Context) {
+	muterID, err := uuid.Parse(c.GetString("account_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_token", Message: "Malformed account_id in token"})
+		return
+	}
+	mutedID, err := uuid.Parse(c.Param("accountId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid account ID",
+		})
+		return
+	}
+
+	ctx := c.Ctx()
+
+	result, err := h.db.NewDelete().
+		Model((*Mute)(nil)).
+		Where("muter_id = ? AND muted_id = ?", muterID, mutedID).
+		Exec(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		c.JSON(http.StatusNotFound, middleware.ErrorResponse{
+			Error:   "not_found",
+			Message: "Mute not found",
+		})
+		return
+	}
+
+	h.hub.SetMuted(muterID, mutedID, false)
+
+	c.JSON(http.StatusOK, pulpgin.H{"status": "unmuted"})
+}
+
+func (h *MutesHandler) ListMuted(c *pulpgin.Context) {
+	muterID, err := uuid.Parse(c.GetString("account_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_token", Message: "Malformed account_id in token"})
+		return
+	}
+	page, err := parsePageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_request", Message: err.Error()})
+		return
+	}
+	ctx := c.Ctx()
+
+	q := h.db.NewSelect().
+		Model((*Mute)(nil)).
+		Where("muter_id = ?", muterID)
+
+	var muteRows []Mute
+	if err := page.apply(q, "created_at", "id").Scan(ctx, &muteRows); err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+	muteRows, next := nextPage(muteRows, page, muteCursor)
+
+	muted := make([]MutedUser, 0, len(muteRows))
+	for _, m := range muteRows {
+		muted = append(muted, MutedUser{
+			AccountID: m.MutedID,
+			Since:     m.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, pulpgin.H{"mutes": muted, "next_cursor": next})
+}
+
+func muteCursor(m Mute) pageCursor {
+	return pageCursor{At: m.CreatedAt, ID: m.ID}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	pulpgin "github.com/BananaLabs-OSS/Fiber/pulp/gin"
+	"github.com/BananaLabs-OSS/Fiber/pulp/gin/middleware"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// otherSideExpr picks the far end of a friendships row relative to the
+// bound account.
+const otherSideExpr = "(CASE WHEN requester_id = ? THEN addressee_id ELSE requester_id END)"
+
+// friendIDsQuery selects the account IDs of accountID's accepted
+// friends, for use as a subquery.
+func friendIDsQuery(db bun.IDB, accountID uuid.UUID) *bun.SelectQuery {
+	return db.NewSelect().
+		TableExpr("friendships").
+		ColumnExpr(otherSideExpr, accountID).
+		Where("(requester_id = ? OR addressee_id = ?) AND status = ?", accountID, accountID, StatusAccepted)
+}
+
+// mutualQuery selects accountA's friendships with friends accountB also
+// has, minus anyone either of them has blocked or been blocked by.
+func (h *FriendsHandler) mutualQuery(accountA, accountB uuid.UUID) *bun.SelectQuery {
+	now := time.Now().UTC()
+	return h.db.NewSelect().
+		Model((*Friendship)(nil)).
+		Where("(requester_id = ? OR addressee_id = ?) AND status = ?", accountA, accountA, StatusAccepted).
+		Where(otherSideExpr+" IN (?)", accountA, friendIDsQuery(h.db, accountB)).
+		Where("NOT EXISTS (SELECT 1 FROM blocks AS b WHERE ((b.blocker_id IN (?, ?) AND b.blocked_id = "+otherSideExpr+") OR (b.blocked_id IN (?, ?) AND b.blocker_id = "+otherSideExpr+")) AND (b.expires_at IS NULL OR b.expires_at > ?))",
+			accountA, accountB, accountA, accountA, accountB, accountA, now)
+}
+
+// haveMutualFriend reports whether accountA and accountB share at least
+// one accepted friend.
+func (h *FriendsHandler) haveMutualFriend(ctx context.Context, accountA, accountB uuid.UUID) (bool, error) {
+	return h.db.NewSelect().
+		Model((*Friendship)(nil)).
+		Where("(requester_id = ? OR addressee_id = ?) AND status = ?", accountB, accountB, StatusAccepted).
+		Where(otherSideExpr+" IN (?)", accountB, friendIDsQuery(h.db, accountA)).
+		Exists(ctx)
+}
+
+// ListMutual returns the friends the caller shares with another
+// account, ordered by how long the caller has been friends with each.
+func (h *FriendsHandler) ListMutual(c *pulpgin.Context) {
+	accountID, err := uuid.Parse(c.GetString("account_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_token", Message: "Malformed account_id in token"})
+		return
+	}
+	otherID, err := uuid.Parse(c.Param("accountId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid account ID",
+		})
+		return
+	}
+	if otherID == accountID {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "self_mutual",
+			Message: "Cannot list mutual friends with yourself",
+		})
+		return
+	}
+	page, err := parsePageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_request", Message: err.Error()})
+		return
+	}
+
+	ctx := c.Ctx()
+
+	blocked, err := blockedEitherWay(ctx, h.db, accountID, otherID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+	if blocked {
+		c.JSON(http.StatusForbidden, middleware.ErrorResponse{
+			Error:   "blocked",
+			Message: "Cannot view mutual friends",
+		})
+		return
+	}
+
+	count, err := h.mutualQuery(accountID, otherID).Count(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+
+	var rows []Friendship
+	if err := page.apply(h.mutualQuery(accountID, otherID), "updated_at", "id").Scan(ctx, &rows); err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+	rows, next := nextPage(rows, page, friendshipCursor)
+
+	mutual := make([]Friend, 0, len(rows))
+	for _, f := range rows {
+		friendAccountID := f.AddresseeID
+		if f.AddresseeID == accountID {
+			friendAccountID = f.RequesterID
+		}
+		mutual = append(mutual, Friend{AccountID: friendAccountID, Since: f.UpdatedAt})
+	}
+
+	c.JSON(http.StatusOK, pulpgin.H{
+		"mutual_friends": mutual,
+		"count":          count,
+		"next_cursor":    next,
+	})
+}
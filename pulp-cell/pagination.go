@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	pulpgin "github.com/BananaLabs-OSS/Fiber/pulp/gin"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// pageCursor is the keyset position of the last row on a page: its
+// timestamp plus its id as a tie-breaker, so pages stay stable while
+// rows are added or removed.
+type pageCursor struct {
+	At time.Time
+	ID uuid.UUID
+}
+
+func (p pageCursor) encode() string {
+	raw := p.At.UTC().Format(time.RFC3339Nano) + "|" + p.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	at, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, errors.New("malformed cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	u, err := uuid.Parse(id)
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	return &pageCursor{At: t, ID: u}, nil
+}
+
+// pageParams are the ?limit=, ?cursor= and ?sort= query params shared
+// by the list endpoints.
+type pageParams struct {
+	Limit  int
+	Desc   bool
+	Cursor *pageCursor
+}
+
+// parsePageParams reads the pagination query params. Lists default to
+// newest first.
+func parsePageParams(c *pulpgin.Context) (pageParams, error) {
+	p := pageParams{Limit: defaultPageLimit, Desc: true}
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return p, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		p.Limit = n
+	}
+	switch c.Query("sort") {
+	case "", "desc":
+	case "asc":
+		p.Desc = false
+	default:
+		return p, errors.New("sort must be asc or desc")
+	}
+	if raw := c.Query("cursor"); raw != "" {
+		cur, err := decodeCursor(raw)
+		if err != nil {
+			return p, err
+		}
+		p.Cursor = cur
+	}
+	return p, nil
+}
+
+// apply adds the keyset condition, ordering and limit to q. One row
+// beyond Limit is fetched so nextPage can tell whether another page
+// exists.
+func (p pageParams) apply(q *bun.SelectQuery, timeCol, idCol string) *bun.SelectQuery {
+	op, dir := ">", "ASC"
+	if p.Desc {
+		op, dir = "<", "DESC"
+	}
+	if p.Cursor != nil {
+		q = q.Where(fmt.Sprintf("(%s %s ? OR (%s = ? AND %s %s ?))", timeCol, op, timeCol, idCol, op),
+			p.Cursor.At, p.Cursor.At, p.Cursor.ID)
+	}
+	return q.
+		OrderExpr(fmt.Sprintf("%s %s, %s %s", timeCol, dir, idCol, dir)).
+		Limit(p.Limit + 1)
+}
+
+// nextPage trims the probe row apply fetched and returns the cursor for
+// the following page, or nil on the last one.
+func nextPage[T any](rows []T, p pageParams, key func(T) pageCursor) ([]T, *string) {
+	if len(rows) <= p.Limit {
+		return rows, nil
+	}
+	rows = rows[:p.Limit]
+	next := key(rows[len(rows)-1]).encode()
+	return rows, &next
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/BananaLabs-OSS/Fiber/pulp"
 	pulpgin "github.com/BananaLabs-OSS/Fiber/pulp/gin"
@@ -18,10 +22,220 @@ type FriendLister interface {
 	ListFriendIDs(ctx context.Context, accountID uuid.UUID) ([]uuid.UUID, error)
 }
 
-// PresenceMessage is the JSON envelope sent over WebSocket.
+// LastSeenRecorder persists when an account was last visibly online.
+// Implemented by LastSeenStore.
+type LastSeenRecorder interface {
+	Touch(ctx context.Context, accountID uuid.UUID, at time.Time) error
+}
+
+// SettingsLoader returns an account's privacy settings. Implemented by
+// SettingsStore.
+type SettingsLoader interface {
+	Load(ctx context.Context, accountID uuid.UUID) (AccountSettings, error)
+}
+
+// MuteLister returns the accounts an account has muted. Implemented by
+// MuteStore.
+type MuteLister interface {
+	MutedIDs(ctx context.Context, muterID uuid.UUID) ([]uuid.UUID, error)
+}
+
+// PresenceStatus is the rich state an online account advertises to
+// its friends. Clients pick one of the settable values over /ws;
+// PresenceOffline is only ever derived by the hub.
+type PresenceStatus string
+
+const (
+	PresenceOnline    PresenceStatus = "online"
+	PresenceAway      PresenceStatus = "away"
+	PresenceBusy      PresenceStatus = "busy"
+	PresenceInvisible PresenceStatus = "invisible"
+	PresenceOffline   PresenceStatus = "offline"
+)
+
+// Settable reports whether a client may choose s for itself.
+func (s PresenceStatus) Settable() bool {
+	switch s {
+	case PresenceOnline, PresenceAway, PresenceBusy, PresenceInvisible:
+		return true
+	}
+	return false
+}
+
+// visible is the status friends get to see: invisible accounts appear
+// offline.
+func (s PresenceStatus) visible() PresenceStatus {
+	if s == PresenceInvisible {
+		return PresenceOffline
+	}
+	return s
+}
+
+// maxActivityField caps the length of each free-form Activity string
+// so a client can't make the hub store or fan out arbitrary blobs.
+const maxActivityField = 128
+
+// Activity is the game rich-presence payload a client publishes: what
+// the player is doing and whether friends can join them.
+type Activity struct {
+	Mode      string    `json:"mode,omitempty"`
+	Map       string    `json:"map,omitempty"`
+	ServerID  string    `json:"server_id,omitempty"`
+	PartySize int       `json:"party_size,omitempty"`
+	PartyMax  int       `json:"party_max,omitempty"`
+	Joinable  bool      `json:"joinable"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// normalize validates a client-supplied activity and fills in
+// StartedAt when the client left it out.
+func (a *Activity) normalize(now time.Time) error {
+	for _, field := range []string{a.Mode, a.Map, a.ServerID} {
+		if len(field) > maxActivityField {
+			return fmt.Errorf("activity field longer than %d bytes", maxActivityField)
+		}
+	}
+	if a.PartySize < 0 || a.PartyMax < 0 {
+		return fmt.Errorf("negative party size")
+	}
+	if a.PartyMax > 0 && a.PartySize > a.PartyMax {
+		return fmt.Errorf("party_size exceeds party_max")
+	}
+	if a.StartedAt.IsZero() {
+		a.StartedAt = now
+	}
+	return nil
+}
+
+// PresenceMessage is the JSON envelope sent over WebSocket. AccountID
+// is always the other party: the friend whose presence changed, or the
+// account that sent, accepted or removed a friendship. Seq orders the
+// events of one account; see eventLog.
 type PresenceMessage struct {
-	Type      string `json:"type"`
-	AccountID string `json:"account_id"`
+	Seq       uint64         `json:"seq,omitempty"`
+	Type      string         `json:"type"`
+	AccountID string         `json:"account_id"`
+	Status    PresenceStatus `json:"status,omitempty"`
+	Activity  *Activity      `json:"activity,omitempty"`
+	Request   *FriendRequest `json:"request,omitempty"`
+}
+
+// FriendPresence is one friend's entry in a presence snapshot.
+type FriendPresence struct {
+	AccountID string         `json:"account_id"`
+	Online    bool           `json:"online"`
+	Status    PresenceStatus `json:"status"`
+	Activity  *Activity      `json:"activity,omitempty"`
+}
+
+// SnapshotMessage is sent once to a freshly connected client so it
+// starts out knowing which of its friends are already online.
+type SnapshotMessage struct {
+	Type string `json:"type"`
+	// Seq is the account's latest event seq when the snapshot was
+	// taken; resume from it after a reconnect.
+	Seq     uint64           `json:"seq"`
+	Friends []FriendPresence `json:"friends"`
+}
+
+// ResyncMessage tells a resuming client its missed events are gone and
+// a fresh snapshot follows.
+type ResyncMessage struct {
+	Type string `json:"type"`
+}
+
+// ClientMessage is the original flat JSON frame a client sends over
+// WebSocket, still accepted alongside the versioned ClientEnvelope.
+type ClientMessage struct {
+	Type     string         `json:"type"`
+	Status   PresenceStatus `json:"status,omitempty"`
+	Activity *Activity      `json:"activity,omitempty"`
+}
+
+// PresenceInfo is the presence state of a single account as reported
+// to internal services.
+type PresenceInfo struct {
+	Online    bool           `json:"online"`
+	Status    PresenceStatus `json:"status"`
+	Activity  *Activity      `json:"activity,omitempty"`
+	Platforms []string       `json:"platforms,omitempty"`
+	// LastSeenAt is only filled in for offline accounts.
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+}
+
+// defaultPlatform tags sessions whose client didn't say what it runs
+// on.
+const defaultPlatform = "unknown"
+
+// platformPattern bounds the ?platform= tag clients attach to a
+// session ("desktop", "mobile", "web", ...).
+var platformPattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// session is one live WebSocket belonging to an account.
+type session struct {
+	connID   uint64
+	platform string
+	encoding wireEncoding
+	// topics the session subscribed to; nil means all of them.
+	topics map[string]bool
+	// lastFrame is when the session last sent anything; lastActive is
+	// when it last sent a command other than ping, and stays zero until
+	// it does. Only sessions that have sent one are judged idle.
+	lastFrame  time.Time
+	lastActive time.Time
+	// pinging is set once the session sends a ping, which opts it in
+	// to the heartbeat timeout.
+	pinging bool
+}
+
+func (s *session) subscribed(topic string) bool {
+	return s.topics == nil || s.topics[topic]
+}
+
+// accountPresence is everything the hub knows about one online
+// account. It exists exactly as long as the account has at least one
+// session.
+type accountPresence struct {
+	sessions map[uint64]*session
+	status   PresenceStatus
+	activity *Activity
+	// activityConn is the session that published activity; the
+	// activity goes away when that session does.
+	activityConn uint64
+	// lastTouch is when last_seen_at was last written for the account.
+	lastTouch time.Time
+	// hidden is set when the account's settings hide its online status
+	// from everyone. It trumps status.
+	hidden bool
+	// muted is the set of accounts this one has muted; their presence,
+	// activity and friend requests are not pushed to it.
+	muted map[uuid.UUID]bool
+	// autoAway is set while status is away only because the account
+	// went idle.
+	autoAway bool
+}
+
+// visible is the status friends get to see.
+func (a *accountPresence) visible() PresenceStatus {
+	if a.hidden {
+		return PresenceOffline
+	}
+	return a.status.visible()
+}
+
+// platforms returns the distinct platform tags of the account's
+// sessions in sorted order.
+func (a *accountPresence) platforms() []string {
+	seen := make(map[string]bool, len(a.sessions))
+	out := make([]string, 0, len(a.sessions))
+	for _, s := range a.sessions {
+		if !seen[s.platform] {
+			seen[s.platform] = true
+			out = append(out, s.platform)
+		}
+	}
+	sort.Strings(out)
+	return out
 }
 
 // Hub tracks connected accounts and broadcasts presence changes to
@@ -31,58 +245,436 @@ type PresenceMessage struct {
 type Hub struct {
 	mu sync.Mutex
 
-	// accountToConn maps an authenticated accountID to the connID the
-	// host assigned when it opened the WebSocket. A single account
-	// can only have one conn at a time; re-registration closes the
-	// previous one.
-	accountToConn map[uuid.UUID]uint64
+	// accounts maps an authenticated accountID to its set of sessions,
+	// keyed by the connID the host assigned when it opened each
+	// WebSocket. An account can be connected from several devices at
+	// once; friends only see it go offline when the last one drops.
+	accounts map[uuid.UUID]*accountPresence
+	// logs holds each account's event numbering and replay buffer. An
+	// entry is created on connect and kept for replayRetention after
+	// the last session closes.
+	logs         map[uuid.UUID]*eventLog
+	lastLogSweep time.Time
+
+	// heartbeatTimeout and idleTimeout drive sweepSessions; zero
+	// disables either check.
+	heartbeatTimeout time.Duration
+	idleTimeout      time.Duration
+	lastSessionSweep time.Time
 
-	friends FriendLister
+	friends  FriendLister
+	lastSeen LastSeenRecorder
+	settings SettingsLoader
+	mutes    MuteLister
 }
 
-func NewHub(friends FriendLister) *Hub {
+func NewHub(friends FriendLister, lastSeen LastSeenRecorder, settings SettingsLoader, mutes MuteLister, heartbeatTimeout, idleTimeout time.Duration) *Hub {
 	return &Hub{
-		accountToConn: map[uuid.UUID]uint64{},
-		friends:       friends,
+		accounts:         map[uuid.UUID]*accountPresence{},
+		logs:             map[uuid.UUID]*eventLog{},
+		friends:          friends,
+		lastSeen:         lastSeen,
+		settings:         settings,
+		mutes:            mutes,
+		heartbeatTimeout: heartbeatTimeout,
+		idleTimeout:      idleTimeout,
 	}
 }
 
-// Register adds an account to the presence map. If the account
-// already has a connection registered, the old one is closed first.
-func (h *Hub) Register(accountID uuid.UUID, connID uint64) {
+// mutedEvents are the pushes a muted account no longer causes. Social
+// events that change the friend list itself, like friend_removed, are
+// still delivered.
+var mutedEvents = map[string]bool{
+	"friend_online":           true,
+	"friend_offline":          true,
+	"friend_status":           true,
+	"friend_activity":         true,
+	"friend_request_received": true,
+}
+
+// Register adds a session to the account's presence entry. An empty
+// status keeps whatever the account's other sessions already set
+// (online for the first session). With exclusive set, every other
+// session of the account is closed first — the single-session
+// behaviour older clients rely on.
+func (h *Hub) Register(accountID uuid.UUID, connID uint64, platform string, encoding wireEncoding, status PresenceStatus, exclusive bool) {
+	// Settings are read before taking the lock. If they can't be read
+	// the account stays hidden rather than leaking presence it may
+	// have opted out of.
+	hidden := true
+	if settings, err := h.settings.Load(context.Background(), accountID); err != nil {
+		log.Printf("presence: failed to load settings for %s: %v", accountID, err)
+	} else {
+		hidden = settings.PresenceVisibility == VisibilityNobody
+	}
+	muted := map[uuid.UUID]bool{}
+	if ids, err := h.mutes.MutedIDs(context.Background(), accountID); err != nil {
+		log.Printf("presence: failed to load mutes for %s: %v", accountID, err)
+	} else {
+		for _, id := range ids {
+			muted[id] = true
+		}
+	}
+
+	now := time.Now()
 	h.mu.Lock()
-	if oldConn, exists := h.accountToConn[accountID]; exists && oldConn != connID {
-		_ = pulp.WS.Close(pulp.WSCloseRequest{
-			ConnID: oldConn,
-			Code:   1000,
-			Reason: "reconnected",
-		})
+	h.sweepLogsLocked(now)
+	if events, tracked := h.logs[accountID]; tracked {
+		events.offlineSince = time.Time{}
+	} else {
+		h.logs[accountID] = newEventLog()
+	}
+	before := h.visibleLocked(accountID)
+	acct, exists := h.accounts[accountID]
+	if !exists {
+		acct = &accountPresence{
+			sessions: map[uint64]*session{},
+			status:   PresenceOnline,
+		}
+		h.accounts[accountID] = acct
+	}
+	if exclusive {
+		for oldConn := range acct.sessions {
+			if oldConn == connID {
+				continue
+			}
+			_ = pulp.WS.Close(pulp.WSCloseRequest{
+				ConnID: oldConn,
+				Code:   1000,
+				Reason: "reconnected",
+			})
+			delete(acct.sessions, oldConn)
+		}
+	}
+	// A kicked session takes the activity it published with it.
+	clearedActivity := false
+	if _, kept := acct.sessions[acct.activityConn]; !kept && acct.activity != nil {
+		acct.activity = nil
+		clearedActivity = true
 	}
-	h.accountToConn[accountID] = connID
+	acct.sessions[connID] = &session{
+		connID:    connID,
+		platform:  platform,
+		encoding:  encoding,
+		lastFrame: now,
+	}
+	acct.hidden = hidden
+	acct.muted = muted
+	// A new session counts as activity, undoing an automatic away.
+	if acct.autoAway {
+		acct.autoAway = false
+		acct.status = PresenceOnline
+	}
+	if status != "" {
+		acct.status = status
+	}
+	after := acct.visible()
 	h.mu.Unlock()
+	defer h.sweepSessions(now)
 
-	h.notifyFriends(accountID, "friend_online")
+	if clearedActivity && before != PresenceOffline && after != PresenceOffline {
+		h.notifyFriends(accountID, PresenceMessage{Type: "friend_activity", AccountID: accountID.String()})
+	}
+	h.notifyTransition(accountID, before, after)
 }
 
-// Unregister removes an account from the presence map when its
-// WebSocket closes. Only deletes the map entry when the stored connID
-// matches the one being unregistered — guards against a reconnect race
-// where a new Register overwrites the entry before the old conn's close
-// event fires.
+// Unregister removes a session when its WebSocket closes. Closes for
+// sessions the hub no longer tracks (e.g. ones already kicked by an
+// exclusive Register) are ignored. Friends are told the account went
+// offline only when its last session is gone.
 func (h *Hub) Unregister(accountID uuid.UUID, connID uint64) {
 	h.mu.Lock()
-	if stored, exists := h.accountToConn[accountID]; exists && stored == connID {
-		delete(h.accountToConn, accountID)
+	acct, exists := h.accounts[accountID]
+	if !exists {
+		h.mu.Unlock()
+		return
+	}
+	if _, tracked := acct.sessions[connID]; !tracked {
+		h.mu.Unlock()
+		return
+	}
+	before := acct.visible()
+	delete(acct.sessions, connID)
+	if len(acct.sessions) == 0 {
+		delete(h.accounts, accountID)
+		if events, tracked := h.logs[accountID]; tracked {
+			events.offlineSince = time.Now()
+		}
+		h.mu.Unlock()
+		h.notifyTransition(accountID, before, PresenceOffline)
+		return
+	}
+	clearedActivity := acct.activity != nil && acct.activityConn == connID
+	if clearedActivity {
+		acct.activity = nil
+	}
+	h.mu.Unlock()
+
+	if clearedActivity && before != PresenceOffline {
+		h.notifyFriends(accountID, PresenceMessage{Type: "friend_activity", AccountID: accountID.String()})
+	}
+}
+
+// SetStatus changes the status of a connected account. Frames from a
+// session the hub no longer tracks are ignored.
+func (h *Hub) SetStatus(accountID uuid.UUID, connID uint64, status PresenceStatus) {
+	h.mu.Lock()
+	acct := h.sessionAccountLocked(accountID, connID)
+	if acct == nil {
+		h.mu.Unlock()
+		return
+	}
+	before := acct.visible()
+	acct.status = status
+	acct.autoAway = false
+	after := acct.visible()
+	h.mu.Unlock()
+
+	h.notifyTransition(accountID, before, after)
+}
+
+// SetHidden applies a change to the account's presence visibility
+// setting to its live sessions, if any.
+func (h *Hub) SetHidden(accountID uuid.UUID, hidden bool) {
+	h.mu.Lock()
+	acct, online := h.accounts[accountID]
+	if !online {
+		h.mu.Unlock()
+		return
+	}
+	before := acct.visible()
+	acct.hidden = hidden
+	after := acct.visible()
+	h.mu.Unlock()
+
+	h.notifyTransition(accountID, before, after)
+}
+
+// SetMuted applies a new or removed mute to the muter's live sessions,
+// if any. Muting someone who is visibly online reads as them going
+// offline; unmuting brings them back.
+func (h *Hub) SetMuted(muterID, mutedID uuid.UUID, muted bool) {
+	h.mu.Lock()
+	acct, online := h.accounts[muterID]
+	if !online || acct.muted[mutedID] == muted {
+		h.mu.Unlock()
+		return
+	}
+	visible := h.visibleLocked(mutedID)
+	h.mu.Unlock()
+	// Only a friend's presence was ever on the muter's screen.
+	shown := visible != PresenceOffline && h.isFriend(muterID, mutedID)
+
+	if muted {
+		// Sent before the mute takes effect, or send would drop it.
+		if shown {
+			h.SendToAccount(muterID, PresenceMessage{Type: "friend_offline", AccountID: mutedID.String()})
+		}
+		h.mu.Lock()
+		if acct, online := h.accounts[muterID]; online {
+			acct.muted[mutedID] = true
+		}
+		h.mu.Unlock()
+		return
+	}
+
+	h.mu.Lock()
+	if acct, online := h.accounts[muterID]; online {
+		delete(acct.muted, mutedID)
+	}
+	h.mu.Unlock()
+	if shown {
+		h.introduce(muterID, mutedID)
+	}
+}
+
+// isFriend reports whether accountA and accountB are accepted friends.
+func (h *Hub) isFriend(accountA, accountB uuid.UUID) bool {
+	friendIDs, err := h.friends.ListFriendIDs(context.Background(), accountA)
+	if err != nil {
+		log.Printf("presence: failed to list friends for %s: %v", accountA, err)
+		return false
+	}
+	for _, id := range friendIDs {
+		if id == accountB {
+			return true
+		}
+	}
+	return false
+}
+
+// SetActivity replaces the activity of a connected account; a nil
+// activity clears it. Friends are only told while the account is
+// visible to them.
+func (h *Hub) SetActivity(accountID uuid.UUID, connID uint64, activity *Activity) {
+	h.mu.Lock()
+	acct := h.sessionAccountLocked(accountID, connID)
+	if acct == nil {
+		h.mu.Unlock()
+		return
+	}
+	acct.activity = activity
+	acct.activityConn = connID
+	visible := acct.visible()
+	h.mu.Unlock()
+
+	if visible == PresenceOffline {
+		return
+	}
+	h.notifyFriends(accountID, PresenceMessage{
+		Type:      "friend_activity",
+		AccountID: accountID.String(),
+		Activity:  activity,
+	})
+}
+
+// Touch notes that connID sent a frame. Called on inbound frames; it
+// also drives sweepSessions.
+func (h *Hub) Touch(accountID uuid.UUID, connID uint64) {
+	now := time.Now().UTC()
+	h.mu.Lock()
+	if acct := h.sessionAccountLocked(accountID, connID); acct != nil {
+		acct.sessions[connID].lastFrame = now
 	}
 	h.mu.Unlock()
 
-	h.notifyFriends(accountID, "friend_offline")
+	h.sweepSessions(now)
+}
+
+// Tick runs the periodic session work — last_seen refreshes, heartbeat
+// and idle checks — when it is due. The host gives the cell no timer,
+// so it is called from /health, which the host and load balancers poll,
+// on top of running on frames and connects.
+func (h *Hub) Tick() {
+	h.sweepSessions(time.Now().UTC())
+}
+
+// SendSnapshot sends connID a presence_snapshot covering every
+// accepted friend of accountID. Friends who are invisible, or whom
+// accountID has muted, are reported offline, exactly as the live
+// events would have shown them.
+func (h *Hub) SendSnapshot(accountID uuid.UUID, connID uint64) {
+	friendIDs, err := h.friends.ListFriendIDs(context.Background(), accountID)
+	if err != nil {
+		log.Printf("presence: failed to list friends for %s: %v", accountID, err)
+		return
+	}
+
+	online := h.BulkOnline(friendIDs)
+	snapshot := SnapshotMessage{
+		Type:    "presence_snapshot",
+		Friends: make([]FriendPresence, 0, len(friendIDs)),
+	}
+	h.mu.Lock()
+	var muted map[uuid.UUID]bool
+	if acct, ok := h.accounts[accountID]; ok {
+		muted = acct.muted
+	}
+	if events, tracked := h.logs[accountID]; tracked {
+		snapshot.Seq = events.last
+	}
+	for _, friendID := range friendIDs {
+		entry := FriendPresence{AccountID: friendID.String(), Status: PresenceOffline}
+		if online[friendID] && !muted[friendID] {
+			if visible := h.visibleLocked(friendID); visible != PresenceOffline {
+				entry.Online = true
+				entry.Status = visible
+				entry.Activity = h.accounts[friendID].activity
+			}
+		}
+		snapshot.Friends = append(snapshot.Friends, entry)
+	}
+	h.mu.Unlock()
+
+	h.reply(accountID, connID, snapshot)
+}
+
+// Subscribe sets which topics connID receives pushes for. Frames from
+// a session the hub no longer tracks are ignored.
+func (h *Hub) Subscribe(accountID uuid.UUID, connID uint64, topics map[string]bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if acct := h.sessionAccountLocked(accountID, connID); acct != nil {
+		acct.sessions[connID].topics = topics
+	}
+}
+
+// reply sends v to one session only: snapshots and command replies.
+func (h *Hub) reply(accountID uuid.UUID, connID uint64, v any) {
+	h.mu.Lock()
+	acct := h.sessionAccountLocked(accountID, connID)
+	if acct == nil {
+		h.mu.Unlock()
+		return
+	}
+	enc := acct.sessions[connID].encoding
+	h.mu.Unlock()
+
+	data, err := enc.marshal(v)
+	if err != nil {
+		log.Printf("presence: failed to encode reply to %s: %v", accountID, err)
+		return
+	}
+	if err := pulp.WS.Send(enc.frame(connID, data)); err != nil {
+		log.Printf("presence: failed to reply to %s: %v", accountID, err)
+	}
+}
+
+// SendToAccount delivers msg to every session of accountID. A no-op
+// when the account is offline.
+func (h *Hub) SendToAccount(accountID uuid.UUID, msg PresenceMessage) {
+	h.send([]uuid.UUID{accountID}, msg)
+}
+
+// FriendshipFormed tells two new friends about each other's presence
+// so their friend lists are correct without a reconnect.
+func (h *Hub) FriendshipFormed(accountA, accountB uuid.UUID) {
+	h.introduce(accountA, accountB)
+	h.introduce(accountB, accountA)
+}
+
+// FriendshipEnded tells two former friends to stop showing each other
+// as online.
+func (h *Hub) FriendshipEnded(accountA, accountB uuid.UUID) {
+	h.mu.Lock()
+	visibleA := h.visibleLocked(accountA)
+	visibleB := h.visibleLocked(accountB)
+	h.mu.Unlock()
+
+	if visibleB != PresenceOffline {
+		h.SendToAccount(accountA, PresenceMessage{Type: "friend_offline", AccountID: accountB.String()})
+	}
+	if visibleA != PresenceOffline {
+		h.SendToAccount(accountB, PresenceMessage{Type: "friend_offline", AccountID: accountA.String()})
+	}
+}
+
+// introduce sends viewer a friend_online for subject if subject is
+// visibly online.
+func (h *Hub) introduce(viewer, subject uuid.UUID) {
+	h.mu.Lock()
+	visible := h.visibleLocked(subject)
+	var activity *Activity
+	if acct, online := h.accounts[subject]; online {
+		activity = acct.activity
+	}
+	h.mu.Unlock()
+
+	if visible == PresenceOffline {
+		return
+	}
+	h.SendToAccount(viewer, PresenceMessage{
+		Type:      "friend_online",
+		AccountID: subject.String(),
+		Status:    visible,
+		Activity:  activity,
+	})
 }
 
 // IsOnline reports whether accountID has an active WebSocket.
 func (h *Hub) IsOnline(accountID uuid.UUID) bool {
 	h.mu.Lock()
-	_, online := h.accountToConn[accountID]
+	_, online := h.accounts[accountID]
 	h.mu.Unlock()
 	return online
 }
@@ -93,56 +685,262 @@ func (h *Hub) BulkOnline(accountIDs []uuid.UUID) map[uuid.UUID]bool {
 	defer h.mu.Unlock()
 	result := make(map[uuid.UUID]bool, len(accountIDs))
 	for _, id := range accountIDs {
-		_, online := h.accountToConn[id]
+		_, online := h.accounts[id]
 		result[id] = online
 	}
 	return result
 }
 
+// VisibleOnline returns the subset of accountIDs viewer currently sees
+// online. Invisible accounts and accounts viewer has muted are left
+// out, the same as OnlineFriends.
+func (h *Hub) VisibleOnline(viewer uuid.UUID, accountIDs []uuid.UUID) ([]uuid.UUID, error) {
+	muted, err := h.mutedSet(viewer)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	online := make([]uuid.UUID, 0, len(accountIDs))
+	for _, id := range accountIDs {
+		if !muted[id] && h.visibleLocked(id) != PresenceOffline {
+			online = append(online, id)
+		}
+	}
+	return online, nil
+}
+
+// OnlineFriends returns the entries of friendIDs that viewer currently
+// sees online, with their status and activity. Invisible friends and
+// friends viewer has muted are left out, as in a presence_snapshot.
+func (h *Hub) OnlineFriends(viewer uuid.UUID, friendIDs []uuid.UUID) ([]FriendPresence, error) {
+	muted, err := h.mutedSet(viewer)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	online := make([]FriendPresence, 0, len(friendIDs))
+	for _, id := range friendIDs {
+		if muted[id] {
+			continue
+		}
+		visible := h.visibleLocked(id)
+		if visible == PresenceOffline {
+			continue
+		}
+		online = append(online, FriendPresence{
+			AccountID: id.String(),
+			Online:    true,
+			Status:    visible,
+			Activity:  h.accounts[id].activity,
+		})
+	}
+	return online, nil
+}
+
+// mutedSet loads viewer's mutes from the store, so callers work for
+// viewers without a WebSocket.
+func (h *Hub) mutedSet(viewer uuid.UUID) (map[uuid.UUID]bool, error) {
+	mutedIDs, err := h.mutes.MutedIDs(context.Background(), viewer)
+	if err != nil {
+		return nil, err
+	}
+	muted := make(map[uuid.UUID]bool, len(mutedIDs))
+	for _, id := range mutedIDs {
+		muted[id] = true
+	}
+	return muted, nil
+}
+
+// Presence returns the presence state of a single account.
+func (h *Hub) Presence(accountID uuid.UUID) PresenceInfo {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.presenceLocked(accountID)
+}
+
+// BulkPresence returns the presence state of a batch of accounts in
+// one lock acquisition.
+func (h *Hub) BulkPresence(accountIDs []uuid.UUID) map[uuid.UUID]PresenceInfo {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result := make(map[uuid.UUID]PresenceInfo, len(accountIDs))
+	for _, id := range accountIDs {
+		result[id] = h.presenceLocked(id)
+	}
+	return result
+}
+
 // OnlineCount returns the total number of connected accounts.
 func (h *Hub) OnlineCount() int {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	return len(h.accountToConn)
+	return len(h.accounts)
+}
+
+// presenceLocked reports an account the way internal services see
+// it: invisible accounts show their real status, but accounts hiding
+// their presence through settings read as offline.
+func (h *Hub) presenceLocked(accountID uuid.UUID) PresenceInfo {
+	acct, online := h.accounts[accountID]
+	if !online || acct.hidden {
+		return PresenceInfo{Online: false, Status: PresenceOffline}
+	}
+	return PresenceInfo{
+		Online:    true,
+		Status:    acct.status,
+		Activity:  acct.activity,
+		Platforms: acct.platforms(),
+	}
+}
+
+// sessionAccountLocked returns the account's presence entry if connID
+// is one of its live sessions. Caller must hold h.mu.
+func (h *Hub) sessionAccountLocked(accountID uuid.UUID, connID uint64) *accountPresence {
+	acct, exists := h.accounts[accountID]
+	if !exists {
+		return nil
+	}
+	if _, tracked := acct.sessions[connID]; !tracked {
+		return nil
+	}
+	return acct
+}
+
+// visibleLocked returns the status friends currently see for
+// accountID. Caller must hold h.mu.
+func (h *Hub) visibleLocked(accountID uuid.UUID) PresenceStatus {
+	acct, online := h.accounts[accountID]
+	if !online {
+		return PresenceOffline
+	}
+	return acct.visible()
+}
+
+// recordLastSeen persists at as the account's last_seen_at. Failures
+// are logged; presence keeps working without the record.
+func (h *Hub) recordLastSeen(accountID uuid.UUID, at time.Time) {
+	if err := h.lastSeen.Touch(context.Background(), accountID, at); err != nil {
+		log.Printf("presence: failed to record last seen for %s: %v", accountID, err)
+	}
+}
+
+// notifyTransition tells friends about a change in what they can see
+// of accountID: coming online, going offline, or switching between
+// online statuses. Going invisible reads as going offline.
+//
+// last_seen_at is written on both edges of visibility so invisible
+// time never shows up as "last online just now".
+func (h *Hub) notifyTransition(accountID uuid.UUID, before, after PresenceStatus) {
+	if before != after && (before == PresenceOffline || after == PresenceOffline) {
+		now := time.Now().UTC()
+		h.mu.Lock()
+		if acct, online := h.accounts[accountID]; online {
+			acct.lastTouch = now
+		}
+		h.mu.Unlock()
+		h.recordLastSeen(accountID, now)
+	}
+
+	switch {
+	case before == after:
+		return
+	case after == PresenceOffline:
+		h.notifyFriends(accountID, PresenceMessage{Type: "friend_offline", AccountID: accountID.String()})
+	case before == PresenceOffline:
+		// Coming back from invisible may reveal an activity friends
+		// haven't seen yet.
+		var activity *Activity
+		h.mu.Lock()
+		if acct, online := h.accounts[accountID]; online {
+			activity = acct.activity
+		}
+		h.mu.Unlock()
+		h.notifyFriends(accountID, PresenceMessage{Type: "friend_online", AccountID: accountID.String(), Status: after, Activity: activity})
+	default:
+		h.notifyFriends(accountID, PresenceMessage{Type: "friend_status", AccountID: accountID.String(), Status: after})
+	}
 }
 
 // notifyFriends looks up the user's friends and sends a presence
-// message to each online one via the host's ws_send import.
-func (h *Hub) notifyFriends(accountID uuid.UUID, msgType string) {
+// message to each online one.
+func (h *Hub) notifyFriends(accountID uuid.UUID, msg PresenceMessage) {
 	friendIDs, err := h.friends.ListFriendIDs(context.Background(), accountID)
 	if err != nil {
 		// Parity with native Bunch/internal/presence/hub.go:91.
 		log.Printf("presence: failed to list friends for %s: %v", accountID, err)
 		return
 	}
+	h.send(friendIDs, msg)
+}
 
-	msg := PresenceMessage{Type: msgType, AccountID: accountID.String()}
-	data, err := json.Marshal(msg)
-	if err != nil {
-		return
+// send delivers msg to every session of each online account in
+// accountIDs via the host's ws_send import. Accounts that muted the
+// message's subject are skipped for mutedEvents, and sessions only get
+// the topics they subscribed to.
+//
+// Each recipient with an event log gets msg under its own next seq,
+// and it is buffered for replay even if the account is offline.
+func (h *Hub) send(accountIDs []uuid.UUID, msg PresenceMessage) {
+	var subject uuid.UUID
+	if mutedEvents[msg.Type] {
+		subject, _ = uuid.Parse(msg.AccountID)
 	}
+	topic := eventTopic(msg.Type)
 
 	type target struct {
-		friendID uuid.UUID
-		connID   uint64
+		accountID uuid.UUID
+		connID    uint64
+		encoding  wireEncoding
+		msg       PresenceMessage
 	}
 	h.mu.Lock()
-	targets := make([]target, 0, len(friendIDs))
-	for _, friendID := range friendIDs {
-		if connID, online := h.accountToConn[friendID]; online {
-			targets = append(targets, target{friendID: friendID, connID: connID})
+	targets := make([]target, 0, len(accountIDs))
+	for _, id := range accountIDs {
+		acct, online := h.accounts[id]
+		if online && acct.muted[subject] {
+			continue
+		}
+		events, tracked := h.logs[id]
+		if !tracked {
+			continue
+		}
+		numbered := events.append(msg)
+		if !online {
+			continue
+		}
+		for connID, s := range acct.sessions {
+			if s.subscribed(topic) {
+				targets = append(targets, target{accountID: id, connID: connID, encoding: s.encoding, msg: numbered})
+			}
 		}
 	}
 	h.mu.Unlock()
 
+	// Sessions of the same account share a seq, so each account's copy
+	// is encoded once per encoding in use.
+	type encodedKey struct {
+		accountID uuid.UUID
+		encoding  wireEncoding
+	}
+	encoded := map[encodedKey][]byte{}
 	for _, t := range targets {
-		if err := pulp.WS.Send(pulp.WSSendRequest{
-			ConnID:  t.connID,
-			OpCode:  pulp.WSOpCodeText,
-			Payload: data,
-		}); err != nil {
+		key := encodedKey{t.accountID, t.encoding}
+		data, ok := encoded[key]
+		if !ok {
+			var err error
+			if data, err = t.encoding.marshal(t.msg); err != nil {
+				log.Printf("presence: failed to encode %s as %s: %v", msg.Type, t.encoding, err)
+				continue
+			}
+			encoded[key] = data
+		}
+		if err := pulp.WS.Send(t.encoding.frame(t.connID, data)); err != nil {
 			// Parity with native Bunch/internal/presence/hub.go:111.
-			log.Printf("presence: failed to notify %s: %v", t.friendID, err)
+			log.Printf("presence: failed to notify %s: %v", t.accountID, err)
 		}
 	}
 }
@@ -151,11 +949,13 @@ func (h *Hub) notifyFriends(accountID uuid.UUID, msgType string) {
 // wires the WebSocket upgrade. Mirrors the original Bunch handler.
 type PresenceHandler struct {
 	hub       *Hub
+	lastSeen  *LastSeenStore
+	settings  *SettingsStore
 	jwtSecret []byte
 }
 
-func NewPresenceHandler(hub *Hub, jwtSecret []byte) *PresenceHandler {
-	return &PresenceHandler{hub: hub, jwtSecret: jwtSecret}
+func NewPresenceHandler(hub *Hub, lastSeen *LastSeenStore, settings *SettingsStore, jwtSecret []byte) *PresenceHandler {
+	return &PresenceHandler{hub: hub, lastSeen: lastSeen, settings: settings, jwtSecret: jwtSecret}
 }
 
 // WSHandlers returns the event callbacks pulpgin will install on the
@@ -180,19 +980,69 @@ func (h *PresenceHandler) WSHandlers() pulpgin.WSHandlers {
 				_ = c.Close(1008, "invalid account")
 				return
 			}
+			// Clients that want to connect without flashing online
+			// to their friends pass ?status=invisible. Without it, a
+			// new session inherits the status the account's other
+			// sessions already set.
+			status := PresenceStatus(c.Query["status"])
+			if status != "" && !status.Settable() {
+				_ = c.Close(1008, "invalid status")
+				return
+			}
+			platform := c.Query["platform"]
+			if platform == "" {
+				platform = defaultPlatform
+			}
+			if !platformPattern.MatchString(platform) {
+				_ = c.Close(1008, "invalid platform")
+				return
+			}
+			// ?exclusive=true restores the one-session-per-account
+			// behaviour: every other session of the account is kicked.
+			exclusive := c.Query["exclusive"] == "true" || c.Query["exclusive"] == "1"
+			// ?encoding=msgpack switches the session to binary
+			// MessagePack frames, both ways.
+			encoding := wireEncoding(c.Query["encoding"])
+			if encoding == "" {
+				encoding = encodingJSON
+			}
+			if !encoding.valid() {
+				_ = c.Close(1008, "invalid encoding")
+				return
+			}
+			// ?resume=<seq> replays the events missed since seq
+			// instead of sending a snapshot, when the hub still has
+			// them all.
+			var resume uint64
+			resuming := c.Query["resume"] != ""
+			if resuming {
+				if resume, err = strconv.ParseUint(c.Query["resume"], 10, 64); err != nil {
+					_ = c.Close(1008, "invalid resume")
+					return
+				}
+			}
 			c.Keys["account_id"] = accountID
-			h.hub.Register(accountID, c.ConnID)
+			c.Keys["encoding"] = encoding
+			h.hub.Register(accountID, c.ConnID, platform, encoding, status, exclusive)
+			if resuming && h.hub.Resume(accountID, c.ConnID, resume) {
+				return
+			}
+			h.hub.SendSnapshot(accountID, c.ConnID)
 		},
 		OnFrame: func(c *pulpgin.WSContext) {
-			// Bunch doesn't expect meaningful inbound frames; keep the
-			// connection alive but ignore payload contents.
-		},
-		OnClose: func(c *pulpgin.WSContext) {
-			raw, ok := c.Keys["account_id"]
+			accountID, ok := wsAccountID(c)
 			if !ok {
 				return
 			}
-			accountID, ok := raw.(uuid.UUID)
+			h.hub.Touch(accountID, c.ConnID)
+			encoding, _ := c.Keys["encoding"].(wireEncoding)
+			if encoding == "" {
+				encoding = encodingJSON
+			}
+			h.handleFrame(accountID, c.ConnID, encoding, c.Payload)
+		},
+		OnClose: func(c *pulpgin.WSContext) {
+			accountID, ok := wsAccountID(c)
 			if !ok {
 				return
 			}
@@ -201,6 +1051,16 @@ func (h *PresenceHandler) WSHandlers() pulpgin.WSHandlers {
 	}
 }
 
+// wsAccountID returns the account OnOpen authenticated for this conn.
+func wsAccountID(c *pulpgin.WSContext) (uuid.UUID, bool) {
+	raw, ok := c.Keys["account_id"]
+	if !ok {
+		return uuid.Nil, false
+	}
+	accountID, ok := raw.(uuid.UUID)
+	return accountID, ok
+}
+
 func (h *PresenceHandler) GetPresence(c *pulpgin.Context) {
 	userID, err := uuid.Parse(c.Param("userId"))
 	if err != nil {
@@ -210,9 +1070,19 @@ func (h *PresenceHandler) GetPresence(c *pulpgin.Context) {
 		})
 		return
 	}
+	result := h.hub.BulkPresence([]uuid.UUID{userID})
+	if err := h.fillLastSeen(c.Ctx(), result); err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+	info := result[userID]
 	c.JSON(http.StatusOK, pulpgin.H{
-		"account_id": userID.String(),
-		"online":     h.hub.IsOnline(userID),
+		"account_id":   userID.String(),
+		"online":       info.Online,
+		"status":       info.Status,
+		"activity":     info.Activity,
+		"platforms":    info.Platforms,
+		"last_seen_at": info.LastSeenAt,
 	})
 }
 
@@ -228,14 +1098,47 @@ func (h *PresenceHandler) BulkPresence(c *pulpgin.Context) {
 		return
 	}
 
-	result := h.hub.BulkOnline(req.AccountIDs)
-	presenceMap := make(map[string]bool, len(result))
-	for id, online := range result {
-		presenceMap[id.String()] = online
+	result := h.hub.BulkPresence(req.AccountIDs)
+	if err := h.fillLastSeen(c.Ctx(), result); err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+
+	presenceMap := make(map[string]PresenceInfo, len(result))
+	for id, info := range result {
+		presenceMap[id.String()] = info
 	}
 	c.JSON(http.StatusOK, pulpgin.H{"presence": presenceMap})
 }
 
+// fillLastSeen adds last_seen_at to the offline entries of result,
+// except for accounts that hide their presence.
+func (h *PresenceHandler) fillLastSeen(ctx context.Context, result map[uuid.UUID]PresenceInfo) error {
+	offline := make([]uuid.UUID, 0, len(result))
+	for id, info := range result {
+		if !info.Online {
+			offline = append(offline, id)
+		}
+	}
+	seen, err := h.lastSeen.Lookup(ctx, offline)
+	if err != nil {
+		return err
+	}
+	hidden, err := h.settings.HiddenAccounts(ctx, offline)
+	if err != nil {
+		return err
+	}
+	for id, at := range seen {
+		if hidden[id] {
+			continue
+		}
+		info := result[id]
+		info.LastSeenAt = &at
+		result[id] = info
+	}
+	return nil
+}
+
 func (h *PresenceHandler) OnlineCount(c *pulpgin.Context) {
 	c.JSON(http.StatusOK, pulpgin.H{"online_count": h.hub.OnlineCount()})
 }
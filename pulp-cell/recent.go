@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	pulpgin "github.com/BananaLabs-OSS/Fiber/pulp/gin"
+	"github.com/BananaLabs-OSS/Fiber/pulp/gin/middleware"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+const (
+	// maxRecentPlayers is how many recent players are kept per
+	// account; older entries are dropped as new matches come in.
+	maxRecentPlayers = 50
+	// maxMatchRoster caps a reported roster, since each match writes a
+	// row for every pair of players in it.
+	maxMatchRoster   = 100
+	maxMatchIDLength = 128
+)
+
+// RelationshipResolver is implemented by FriendsHandler.
+type RelationshipResolver interface {
+	Relationships(ctx context.Context, accountID uuid.UUID, others []uuid.UUID) (map[uuid.UUID]Relationship, error)
+}
+
+// RecentHandler records who played together, as reported by game
+// servers, and serves each player their recently played with list.
+type RecentHandler struct {
+	db            *bun.DB
+	relationships RelationshipResolver
+}
+
+func NewRecentHandler(db *bun.DB, relationships RelationshipResolver) *RecentHandler {
+	return &RecentHandler{db: db, relationships: relationships}
+}
+
+// RecordMatch stores a finished match's roster. Every player gets every
+// other player at the front of their recent list.
+func (h *RecentHandler) RecordMatch(c *pulpgin.Context) {
+	var req MatchInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "players is required",
+		})
+		return
+	}
+	if len(req.MatchID) > maxMatchIDLength {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "match_id must be at most " + strconv.Itoa(maxMatchIDLength) + " characters",
+		})
+		return
+	}
+
+	seen := make(map[uuid.UUID]bool, len(req.Players))
+	players := make([]uuid.UUID, 0, len(req.Players))
+	for _, id := range req.Players {
+		if id == uuid.Nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		players = append(players, id)
+	}
+	if len(players) < 2 || len(players) > maxMatchRoster {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "players must list between 2 and " + strconv.Itoa(maxMatchRoster) + " distinct accounts",
+		})
+		return
+	}
+
+	ctx := c.Ctx()
+	now := time.Now().UTC()
+
+	err := h.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		for _, accountID := range players {
+			rows := make([]RecentPlay, 0, len(players)-1)
+			for _, playerID := range players {
+				if playerID == accountID {
+					continue
+				}
+				rows = append(rows, RecentPlay{
+					AccountID: accountID,
+					PlayerID:  playerID,
+					MatchID:   req.MatchID,
+					PlayedAt:  now,
+				})
+			}
+			if _, err := tx.NewInsert().
+				Model(&rows).
+				On("CONFLICT (account_id, player_id) DO UPDATE").
+				Set("match_id = EXCLUDED.match_id").
+				Set("played_at = EXCLUDED.played_at").
+				Exec(ctx); err != nil {
+				return err
+			}
+			if err := trimRecent(ctx, tx, accountID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, pulpgin.H{"status": "recorded", "players": len(players)})
+}
+
+// ListRecent returns the caller's recent players, most recent first by
+// default, each with where the caller stands with them so the client
+// can offer a friend request or block.
+func (h *RecentHandler) ListRecent(c *pulpgin.Context) {
+	accountID, err := uuid.Parse(c.GetString("account_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_token", Message: "Malformed account_id in token"})
+		return
+	}
+	page, err := parsePageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_request", Message: err.Error()})
+		return
+	}
+	ctx := c.Ctx()
+
+	q := h.db.NewSelect().
+		Model((*RecentPlay)(nil)).
+		Where("account_id = ?", accountID)
+
+	var rows []RecentPlay
+	if err := page.apply(q, "played_at", "player_id").Scan(ctx, &rows); err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+	rows, next := nextPage(rows, page, recentCursor)
+
+	ids := make([]uuid.UUID, 0, len(rows))
+	for _, r := range rows {
+		ids = append(ids, r.PlayerID)
+	}
+	relations, err := h.relationships.Relationships(ctx, accountID, ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+
+	players := make([]RecentPlayer, 0, len(rows))
+	for _, r := range rows {
+		relation := relations[r.PlayerID]
+		// Being blocked is not the caller's to know.
+		if relation == RelationBlockedBy {
+			relation = RelationNone
+		}
+		players = append(players, RecentPlayer{
+			AccountID:    r.PlayerID,
+			MatchID:      r.MatchID,
+			PlayedAt:     r.PlayedAt,
+			Relationship: relation,
+		})
+	}
+
+	c.JSON(http.StatusOK, pulpgin.H{"players": players, "next_cursor": next})
+}
+
+// trimRecent drops accountID's entries beyond the newest
+// maxRecentPlayers.
+func trimRecent(ctx context.Context, tx bun.Tx, accountID uuid.UUID) error {
+	keep := tx.NewSelect().
+		Model((*RecentPlay)(nil)).
+		Column("player_id").
+		Where("account_id = ?", accountID).
+		OrderExpr("played_at DESC, player_id DESC").
+		Limit(maxRecentPlayers)
+	_, err := tx.NewDelete().
+		Model((*RecentPlay)(nil)).
+		Where("account_id = ? AND player_id NOT IN (?)", accountID, keep).
+		Exec(ctx)
+	return err
+}
+
+func recentCursor(r RecentPlay) pageCursor {
+	return pageCursor{At: r.PlayedAt, ID: r.PlayerID}
+}
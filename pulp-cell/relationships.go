@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	pulpgin "github.com/BananaLabs-OSS/Fiber/pulp/gin"
+	"github.com/BananaLabs-OSS/Fiber/pulp/gin/middleware"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Relationships reports how accountID stands with each of others. A
+// block outranks anything else between the pair; expired requests
+// count as nothing.
+func (h *FriendsHandler) Relationships(ctx context.Context, accountID uuid.UUID, others []uuid.UUID) (map[uuid.UUID]Relationship, error) {
+	result := make(map[uuid.UUID]Relationship, len(others))
+	if len(others) == 0 {
+		return result, nil
+	}
+	for _, id := range others {
+		result[id] = RelationNone
+	}
+
+	var friendships []Friendship
+	if err := h.db.NewSelect().
+		Model(&friendships).
+		Where("(requester_id = ? AND addressee_id IN (?)) OR (addressee_id = ? AND requester_id IN (?))",
+			accountID, bun.In(others), accountID, bun.In(others)).
+		Where("status IN (?)", bun.In([]FriendshipStatus{StatusPending, StatusAccepted})).
+		Scan(ctx); err != nil {
+		return nil, err
+	}
+	for _, f := range friendships {
+		if h.expired(f) {
+			continue
+		}
+		switch {
+		case f.Status == StatusAccepted && f.RequesterID == accountID:
+			result[f.AddresseeID] = RelationFriends
+		case f.Status == StatusAccepted:
+			result[f.RequesterID] = RelationFriends
+		case f.RequesterID == accountID:
+			result[f.AddresseeID] = RelationPendingOutgoing
+		default:
+			result[f.RequesterID] = RelationPendingIncoming
+		}
+	}
+
+	var blocks []Block
+	if err := h.db.NewSelect().
+		Model(&blocks).
+		Where("(blocker_id = ? AND blocked_id IN (?)) OR (blocked_id = ? AND blocker_id IN (?))",
+			accountID, bun.In(others), accountID, bun.In(others)).
+		Where(activeBlockExpr, time.Now().UTC()).
+		Scan(ctx); err != nil {
+		return nil, err
+	}
+	// Apply blocked_by first so a block in both directions reads as
+	// the caller's own.
+	for _, b := range blocks {
+		if b.BlockedID == accountID {
+			result[b.BlockerID] = RelationBlockedBy
+		}
+	}
+	for _, b := range blocks {
+		if b.BlockerID == accountID {
+			result[b.BlockedID] = RelationBlocked
+		}
+	}
+	return result, nil
+}
+
+// maxBulkRelationships caps how many accounts one bulk relationship
+// query may check.
+const maxBulkRelationships = 200
+
+// GetRelationship reports how one account stands with another, for
+// internal services: friends, pending in either direction, blocked
+// either way, or none.
+func (h *FriendsHandler) GetRelationship(c *pulpgin.Context) {
+	accountID, err := uuid.Parse(c.Param("accountId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid account ID",
+		})
+		return
+	}
+	otherID, err := uuid.Parse(c.Param("otherId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_id",
+			Message: "Invalid account ID",
+		})
+		return
+	}
+	if accountID == otherID {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "self_relationship",
+			Message: "An account has no relationship with itself",
+		})
+		return
+	}
+
+	relations, err := h.Relationships(c.Ctx(), accountID, []uuid.UUID{otherID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, pulpgin.H{
+		"account_id":   accountID,
+		"other_id":     otherID,
+		"relationship": relations[otherID],
+	})
+}
+
+// BulkRelationships checks one account against many in one request.
+// The account itself is left out of the result.
+func (h *FriendsHandler) BulkRelationships(c *pulpgin.Context) {
+	var req struct {
+		AccountID  uuid.UUID   `json:"account_id" binding:"required"`
+		AccountIDs []uuid.UUID `json:"account_ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "account_id and account_ids are required",
+		})
+		return
+	}
+	if len(req.AccountIDs) > maxBulkRelationships {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_request",
+			Message: fmt.Sprintf("account_ids may list at most %d accounts", maxBulkRelationships),
+		})
+		return
+	}
+
+	others := make([]uuid.UUID, 0, len(req.AccountIDs))
+	for _, id := range req.AccountIDs {
+		if id != req.AccountID {
+			others = append(others, id)
+		}
+	}
+
+	relations, err := h.Relationships(c.Ctx(), req.AccountID, others)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+
+	result := make(map[string]Relationship, len(relations))
+	for id, r := range relations {
+		result[id.String()] = r
+	}
+	c.JSON(http.StatusOK, pulpgin.H{"account_id": req.AccountID, "relationships": result})
+}
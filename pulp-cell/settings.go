@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	pulpgin "github.com/BananaLabs-OSS/Fiber/pulp/gin"
+	"github.com/BananaLabs-OSS/Fiber/pulp/gin/middleware"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// defaultSettings is what an account that never saved settings gets.
+func defaultSettings(accountID uuid.UUID) AccountSettings {
+	return AccountSettings{
+		AccountID:          accountID,
+		FriendRequests:     RequestsEveryone,
+		PresenceVisibility: VisibilityFriends,
+	}
+}
+
+// SettingsStore reads and writes per-account privacy settings.
+type SettingsStore struct {
+	db *bun.DB
+}
+
+func NewSettingsStore(db *bun.DB) *SettingsStore {
+	return &SettingsStore{db: db}
+}
+
+// Load returns the account's settings, or the defaults if it never
+// saved any.
+func (s *SettingsStore) Load(ctx context.Context, accountID uuid.UUID) (AccountSettings, error) {
+	var settings AccountSettings
+	err := s.db.NewSelect().
+		Model(&settings).
+		Where("account_id = ?", accountID).
+		Scan(ctx)
+	if err == sql.ErrNoRows {
+		return defaultSettings(accountID), nil
+	}
+	return settings, err
+}
+
+// HiddenAccounts returns which of accountIDs chose to hide their
+// online status from everyone.
+func (s *SettingsStore) HiddenAccounts(ctx context.Context, accountIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	result := make(map[uuid.UUID]bool, len(accountIDs))
+	if len(accountIDs) == 0 {
+		return result, nil
+	}
+	var rows []AccountSettings
+	if err := s.db.NewSelect().
+		Model(&rows).
+		Where("account_id IN (?) AND presence_visibility = ?", bun.In(accountIDs), VisibilityNobody).
+		Scan(ctx); err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		result[r.AccountID] = true
+	}
+	return result, nil
+}
+
+func (s *SettingsStore) Save(ctx context.Context, settings *AccountSettings) error {
+	_, err := s.db.NewInsert().
+		Model(settings).
+		On("CONFLICT (account_id) DO UPDATE").
+		Set("friend_requests = EXCLUDED.friend_requests").
+		Set("presence_visibility = EXCLUDED.presence_visibility").
+		Set("updated_at = EXCLUDED.updated_at").
+		Exec(ctx)
+	return err
+}
+
+// VisibilitySetter is told when an account changes who can see it
+// online. Implemented by Hub.
+type VisibilitySetter interface {
+	SetHidden(accountID uuid.UUID, hidden bool)
+}
+
+type SettingsHandler struct {
+	store *SettingsStore
+	hub   VisibilitySetter
+}
+
+func NewSettingsHandler(store *SettingsStore, hub VisibilitySetter) *SettingsHandler {
+	return &SettingsHandler{store: store, hub: hub}
+}
+
+func (h *SettingsHandler) GetSettings(c *pulpgin.Context) {
+	accountID, err := uuid.Parse(c.GetString("account_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_token", Message: "Malformed account_id in token"})
+		return
+	}
+
+	settings, err := h.store.Load(c.Ctx(), accountID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateSettings applies a partial update: fields left out of the body
+// keep their current value.
+func (h *SettingsHandler) UpdateSettings(c *pulpgin.Context) {
+	accountID, err := uuid.Parse(c.GetString("account_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_token", Message: "Malformed account_id in token"})
+		return
+	}
+
+	var req SettingsInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Invalid settings body",
+		})
+		return
+	}
+	if req.FriendRequests != nil && !req.FriendRequests.Valid() {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "friend_requests must be everyone, friends_of_friends or nobody",
+		})
+		return
+	}
+	if req.PresenceVisibility != nil && !req.PresenceVisibility.Valid() {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "presence_visibility must be friends or nobody",
+		})
+		return
+	}
+
+	ctx := c.Ctx()
+
+	settings, err := h.store.Load(ctx, accountID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+	if req.FriendRequests != nil {
+		settings.FriendRequests = *req.FriendRequests
+	}
+	if req.PresenceVisibility != nil {
+		settings.PresenceVisibility = *req.PresenceVisibility
+	}
+	settings.UpdatedAt = time.Now().UTC()
+
+	if err := h.store.Save(ctx, &settings); err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "update_failed"})
+		return
+	}
+
+	h.hub.SetHidden(accountID, settings.PresenceVisibility == VisibilityNobody)
+
+	c.JSON(http.StatusOK, settings)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	pulpgin "github.com/BananaLabs-OSS/Fiber/pulp/gin"
+	"github.com/BananaLabs-OSS/Fiber/pulp/gin/middleware"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+const (
+	// suggestionCacheTTL is how long a computed ranking is reused.
+	suggestionCacheTTL = 10 * time.Minute
+	// maxSuggestionCandidates bounds how many ranked accounts are kept
+	// per cached entry.
+	maxSuggestionCandidates = 100
+	defaultSuggestionLimit  = 20
+)
+
+// Suggestion is a non-friend the caller may want to add, with the
+// signals that ranked it.
+type Suggestion struct {
+	AccountID     uuid.UUID `bun:"account_id" json:"account_id"`
+	MutualFriends int       `bun:"mutual_friends" json:"mutual_friends"`
+	Score         int       `bun:"-" json:"score"`
+}
+
+type cachedSuggestions struct {
+	computedAt  time.Time
+	suggestions []Suggestion
+}
+
+// SuggestionsHandler ranks friends-of-friends. The graph walk is
+// cached per account; cached entries are re-checked against the
+// caller's current friends, requests and blocks on every read, so a
+// stale ranking never suggests someone who is no longer eligible.
+type SuggestionsHandler struct {
+	db *bun.DB
+
+	mu    sync.Mutex
+	cache map[uuid.UUID]cachedSuggestions
+}
+
+func NewSuggestionsHandler(db *bun.DB) *SuggestionsHandler {
+	return &SuggestionsHandler{db: db, cache: map[uuid.UUID]cachedSuggestions{}}
+}
+
+func (h *SuggestionsHandler) ListSuggestions(c *pulpgin.Context) {
+	accountID, err := uuid.Parse(c.GetString("account_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, middleware.ErrorResponse{Error: "invalid_token", Message: "Malformed account_id in token"})
+		return
+	}
+	limit := defaultSuggestionLimit
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxSuggestionCandidates {
+			c.JSON(http.StatusBadRequest, middleware.ErrorResponse{
+				Error:   "invalid_request",
+				Message: "limit must be between 1 and " + strconv.Itoa(maxSuggestionCandidates),
+			})
+			return
+		}
+		limit = n
+	}
+
+	ctx := c.Ctx()
+
+	ranked, err := h.ranked(ctx, accountID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+	related, err := relatedAccounts(ctx, h.db, accountID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, middleware.ErrorResponse{Error: "database_error"})
+		return
+	}
+
+	suggestions := make([]Suggestion, 0, limit)
+	for _, s := range ranked {
+		if related[s.AccountID] {
+			continue
+		}
+		suggestions = append(suggestions, s)
+		if len(suggestions) == limit {
+			break
+		}
+	}
+
+	c.JSON(http.StatusOK, pulpgin.H{"suggestions": suggestions})
+}
+
+// ranked returns the cached ranking for accountID, recomputing it when
+// missing or older than suggestionCacheTTL.
+func (h *SuggestionsHandler) ranked(ctx context.Context, accountID uuid.UUID) ([]Suggestion, error) {
+	now := time.Now()
+	h.mu.Lock()
+	entry, ok := h.cache[accountID]
+	h.mu.Unlock()
+	if ok && now.Sub(entry.computedAt) < suggestionCacheTTL {
+		return entry.suggestions, nil
+	}
+
+	suggestions, err := h.compute(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	for id, e := range h.cache {
+		if now.Sub(e.computedAt) >= suggestionCacheTTL {
+			delete(h.cache, id)
+		}
+	}
+	h.cache[accountID] = cachedSuggestions{computedAt: now, suggestions: suggestions}
+	h.mu.Unlock()
+	return suggestions, nil
+}
+
+// compute walks friends-of-friends in SQL and ranks them by how many
+// friends they share with accountID. Related accounts are excluded
+// before the LIMIT so well-connected accounts still get a full list.
+func (h *SuggestionsHandler) compute(ctx context.Context, accountID uuid.UUID) ([]Suggestion, error) {
+	var suggestions []Suggestion
+	err := h.db.NewRaw(`
+		WITH mine AS (
+			SELECT CASE WHEN requester_id = ?0 THEN addressee_id ELSE requester_id END AS friend_id
+			FROM friendships
+			WHERE (requester_id = ?0 OR addressee_id = ?0) AND status = ?3
+		), candidates AS (
+			SELECT CASE WHEN f.requester_id = m.friend_id THEN f.addressee_id ELSE f.requester_id END AS account_id
+			FROM mine AS m
+			JOIN friendships AS f
+				ON (f.requester_id = m.friend_id OR f.addressee_id = m.friend_id) AND f.status = ?3
+		)
+		SELECT account_id, COUNT(*) AS mutual_friends
+		FROM candidates
+		WHERE account_id <> ?0 AND account_id NOT IN (`+relatedAccountsQuery+`)
+		GROUP BY account_id
+		ORDER BY mutual_friends DESC, account_id
+		LIMIT ?4`,
+		accountID, bun.In(relatedStatuses), time.Now().UTC(), StatusAccepted, maxSuggestionCandidates).
+		Scan(ctx, &suggestions)
+	if err != nil {
+		return nil, err
+	}
+	for i := range suggestions {
+		suggestions[i].Score = suggestions[i].MutualFriends
+	}
+	return suggestions, nil
+}
+
+// relatedAccountsQuery selects everyone ?0 must not be suggested:
+// friends and pending requests in either direction (status IN ?1) and
+// blocks either way still in force at ?2.
+const relatedAccountsQuery = `
+		SELECT CASE WHEN requester_id = ?0 THEN addressee_id ELSE requester_id END
+		FROM friendships
+		WHERE (requester_id = ?0 OR addressee_id = ?0) AND status IN (?1)
+		UNION
+		SELECT blocked_id FROM blocks WHERE blocker_id = ?0 AND (expires_at IS NULL OR expires_at > ?2)
+		UNION
+		SELECT blocker_id FROM blocks WHERE blocked_id = ?0 AND (expires_at IS NULL OR expires_at > ?2)`
+
+var relatedStatuses = []FriendshipStatus{StatusAccepted, StatusPending}
+
+// relatedAccounts returns everyone accountID must not be suggested:
+// friends, pending requests in either direction, and blocks either
+// way.
+func relatedAccounts(ctx context.Context, db bun.IDB, accountID uuid.UUID) (map[uuid.UUID]bool, error) {
+	var ids []uuid.UUID
+	err := db.NewRaw(relatedAccountsQuery, accountID, bun.In(relatedStatuses), time.Now().UTC()).
+		Scan(ctx, &ids)
+	if err != nil {
+		return nil, err
+	}
+	related := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		related[id] = true
+	}
+	return related, nil
+}
@@ -1,6 +1,7 @@
 // Manual WS presence test: two clients connect with JWTs, the test
-// asserts that (a) online_count reflects the live connections, and
-// (b) a presence event is delivered to the friend when the other
+// asserts that (a) each client receives a presence_snapshot on
+// connect, (b) online_count reflects the live connections, and
+// (c) a presence event is delivered to the friend when the other
 // connects.
 //
 //	go run ./testtools/wspresence -base http://127.0.0.1:8769 \
@@ -48,6 +49,13 @@ func main() {
 	defer connA.Close(websocket.StatusNormalClosure, "bye")
 	fmt.Println("A connected")
 
+	// A's snapshot must list B as an offline friend.
+	snap := readMessage(ctx, connA, "A read snapshot")
+	if snap.Type != "presence_snapshot" || !snapshotHas(snap, *accountB, false) {
+		fmt.Fprintf(os.Stderr, "want presence_snapshot with B offline, got %+v\n", snap)
+		os.Exit(1)
+	}
+
 	// Give the host a moment to Register before B joins so we can
 	// observe A receiving B's "friend_online" on the same socket.
 	time.Sleep(200 * time.Millisecond)
@@ -66,16 +74,16 @@ func main() {
 	defer connB.Close(websocket.StatusNormalClosure, "bye")
 	fmt.Println("B connected")
 
-	readCtx, readCancel := context.WithTimeout(ctx, 5*time.Second)
-	defer readCancel()
-	_, data, err := connA.Read(readCtx)
-	must(err, "A read")
-	fmt.Printf("A received: %s\n", data)
+	// B's snapshot must already show A online.
+	snap = readMessage(ctx, connB, "B read snapshot")
+	if snap.Type != "presence_snapshot" || !snapshotHas(snap, *accountA, true) {
+		fmt.Fprintf(os.Stderr, "want presence_snapshot with A online, got %+v\n", snap)
+		os.Exit(1)
+	}
 
-	var msg map[string]string
-	_ = json.Unmarshal(data, &msg)
-	if msg["type"] != "friend_online" || msg["account_id"] != *accountB {
-		fmt.Fprintf(os.Stderr, "want friend_online from %s, got %s from %s\n", *accountB, msg["type"], msg["account_id"])
+	msg := readMessage(ctx, connA, "A read")
+	if msg.Type != "friend_online" || msg.AccountID != *accountB {
+		fmt.Fprintf(os.Stderr, "want friend_online from %s, got %s from %s\n", *accountB, msg.Type, msg.AccountID)
 		os.Exit(1)
 	}
 
@@ -88,21 +96,46 @@ func main() {
 
 	// B disconnects; A should see friend_offline.
 	_ = connB.Close(websocket.StatusNormalClosure, "leaving")
-	readCtx2, readCancel2 := context.WithTimeout(ctx, 5*time.Second)
-	defer readCancel2()
-	_, data, err = connA.Read(readCtx2)
-	must(err, "A read after B close")
-	fmt.Printf("A received: %s\n", data)
-
-	_ = json.Unmarshal(data, &msg)
-	if msg["type"] != "friend_offline" || msg["account_id"] != *accountB {
-		fmt.Fprintf(os.Stderr, "want friend_offline from %s, got %s from %s\n", *accountB, msg["type"], msg["account_id"])
+	msg = readMessage(ctx, connA, "A read after B close")
+	if msg.Type != "friend_offline" || msg.AccountID != *accountB {
+		fmt.Fprintf(os.Stderr, "want friend_offline from %s, got %s from %s\n", *accountB, msg.Type, msg.AccountID)
 		os.Exit(1)
 	}
 
 	fmt.Println("PASS")
 }
 
+// message covers both presence events and snapshots.
+type message struct {
+	Type      string `json:"type"`
+	AccountID string `json:"account_id"`
+	Friends   []struct {
+		AccountID string `json:"account_id"`
+		Online    bool   `json:"online"`
+	} `json:"friends"`
+}
+
+func readMessage(ctx context.Context, conn *websocket.Conn, what string) message {
+	readCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, data, err := conn.Read(readCtx)
+	must(err, what)
+	fmt.Printf("%s: %s\n", what, data)
+
+	var msg message
+	must(json.Unmarshal(data, &msg), what+" decode")
+	return msg
+}
+
+func snapshotHas(msg message, accountID string, online bool) bool {
+	for _, f := range msg.Friends {
+		if f.AccountID == accountID {
+			return f.Online == online
+		}
+	}
+	return false
+}
+
 func signJWT(secret, accountID string) string {
 	claims := jwt.MapClaims{
 		"account_id": accountID,
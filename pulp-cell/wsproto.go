@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// wsProtocolVersion is the version of the /ws command envelope.
+// Frames without a version are read as the original flat
+// ClientMessage and get no replies.
+const wsProtocolVersion = 1
+
+// Topics a session can subscribe to. Every session starts subscribed
+// to all of them.
+const (
+	TopicPresence = "presence"
+	TopicSocial   = "social"
+)
+
+// presenceEvents are the pushes under TopicPresence; every other push
+// is TopicSocial.
+var presenceEvents = map[string]bool{
+	"friend_online":   true,
+	"friend_offline":  true,
+	"friend_status":   true,
+	"friend_activity": true,
+}
+
+func eventTopic(msgType string) string {
+	if presenceEvents[msgType] {
+		return TopicPresence
+	}
+	return TopicSocial
+}
+
+// ClientEnvelope is a versioned command from a client. ID is chosen by
+// the client and echoed on the ack or error that answers it.
+type ClientEnvelope struct {
+	V       int        `json:"v"`
+	Type    string     `json:"type"`
+	ID      string     `json:"id,omitempty"`
+	Payload rawPayload `json:"payload,omitempty"`
+}
+
+// ServerReply answers a ClientEnvelope with type "ack" or "error".
+type ServerReply struct {
+	V       int    `json:"v"`
+	Type    string `json:"type"`
+	ID      string `json:"id,omitempty"`
+	Payload any    `json:"payload,omitempty"`
+}
+
+// CommandError is the payload of an "error" reply.
+type CommandError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type setStatusPayload struct {
+	Status PresenceStatus `json:"status"`
+}
+
+type setActivityPayload struct {
+	Activity *Activity `json:"activity"`
+}
+
+type subscribePayload struct {
+	Topics []string `json:"topics"`
+}
+
+// handleFrame decodes one inbound frame and runs it. Versioned
+// commands always get an ack or an error; legacy frames are handled
+// silently as before.
+func (h *PresenceHandler) handleFrame(accountID uuid.UUID, connID uint64, enc wireEncoding, data []byte) {
+	var env ClientEnvelope
+	if err := enc.unmarshal(data, &env); err != nil {
+		h.hub.reply(accountID, connID, errorReply("", "bad_frame", "frame is not a valid "+string(enc)+" object"))
+		return
+	}
+	// Only JSON sessions predate the envelope; anything else must
+	// version its frames.
+	if env.V == 0 && enc == encodingJSON {
+		h.handleLegacy(accountID, connID, data)
+		return
+	}
+	if env.V != wsProtocolVersion {
+		h.hub.reply(accountID, connID, errorReply(env.ID, "unsupported_version", "protocol version must be 1"))
+		return
+	}
+	if env.Type != "ping" {
+		h.hub.Active(accountID, connID)
+	}
+	h.hub.reply(accountID, connID, h.runCommand(accountID, connID, enc, env))
+}
+
+// runCommand executes a versioned command and returns its reply.
+func (h *PresenceHandler) runCommand(accountID uuid.UUID, connID uint64, enc wireEncoding, env ClientEnvelope) ServerReply {
+	switch env.Type {
+	case "ping":
+		h.hub.Heartbeat(accountID, connID)
+		return ackReply(env.ID, map[string]time.Time{"server_time": time.Now().UTC()})
+
+	case "set_status":
+		var p setStatusPayload
+		if err := decodePayload(enc, env.Payload, &p); err != nil {
+			return errorReply(env.ID, "invalid_payload", err.Error())
+		}
+		if !p.Status.Settable() {
+			return errorReply(env.ID, "invalid_status", "status must be online, away, busy or invisible")
+		}
+		h.hub.SetStatus(accountID, connID, p.Status)
+		return ackReply(env.ID, nil)
+
+	case "set_activity":
+		var p setActivityPayload
+		if err := decodePayload(enc, env.Payload, &p); err != nil {
+			return errorReply(env.ID, "invalid_payload", err.Error())
+		}
+		if p.Activity == nil {
+			return errorReply(env.ID, "invalid_activity", "activity is required")
+		}
+		if err := p.Activity.normalize(time.Now().UTC()); err != nil {
+			return errorReply(env.ID, "invalid_activity", err.Error())
+		}
+		h.hub.SetActivity(accountID, connID, p.Activity)
+		return ackReply(env.ID, nil)
+
+	case "clear_activity":
+		h.hub.SetActivity(accountID, connID, nil)
+		return ackReply(env.ID, nil)
+
+	case "subscribe":
+		var p subscribePayload
+		if err := decodePayload(enc, env.Payload, &p); err != nil {
+			return errorReply(env.ID, "invalid_payload", err.Error())
+		}
+		topics := make(map[string]bool, len(p.Topics))
+		for _, t := range p.Topics {
+			if t != TopicPresence && t != TopicSocial {
+				return errorReply(env.ID, "invalid_topic", "topics must be presence or social")
+			}
+			topics[t] = true
+		}
+		h.hub.Subscribe(accountID, connID, topics)
+		return ackReply(env.ID, subscribePayload{Topics: p.Topics})
+
+	default:
+		return errorReply(env.ID, "unknown_type", fmt.Sprintf("unknown command type %q", env.Type))
+	}
+}
+
+// handleLegacy runs a pre-envelope ClientMessage. Only JSON sessions
+// ever spoke the flat format. Malformed or unknown
+// frames are ignored; the connection stays open.
+func (h *PresenceHandler) handleLegacy(accountID uuid.UUID, connID uint64, data []byte) {
+	var msg ClientMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return
+	}
+	h.hub.Active(accountID, connID)
+	switch msg.Type {
+	case "set_status":
+		if msg.Status.Settable() {
+			h.hub.SetStatus(accountID, connID, msg.Status)
+		}
+	case "set_activity":
+		if msg.Activity == nil || msg.Activity.normalize(time.Now().UTC()) != nil {
+			return
+		}
+		h.hub.SetActivity(accountID, connID, msg.Activity)
+	case "clear_activity":
+		h.hub.SetActivity(accountID, connID, nil)
+	}
+}
+
+// decodePayload reads a command payload into v. A missing payload
+// leaves v at its zero value.
+func decodePayload(enc wireEncoding, raw rawPayload, v any) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	return enc.unmarshal(raw, v)
+}
+
+func ackReply(id string, payload any) ServerReply {
+	return ServerReply{V: wsProtocolVersion, Type: "ack", ID: id, Payload: payload}
+}
+
+func errorReply(id, code, message string) ServerReply {
+	return ServerReply{
+		V:       wsProtocolVersion,
+		Type:    "error",
+		ID:      id,
+		Payload: CommandError{Code: code, Message: message},
+	}
+}